@@ -0,0 +1,499 @@
+package mealplanner
+
+// analyzers break text into the keywords stored in the index, each
+//  library uses the analyzer for its Language
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// breaks text into words and keywords for a language
+type analyzer interface {
+	// break the text into lower case words, in the order they appear
+	tokenize(text string) []string
+	// tokenize, and give the position of each word in the text
+	spans(text string) []wordSpan
+	// get the keyword indexed for a word from tokenize,
+	//  empty if the word isn't indexed (e.g. "the")
+	keyword(word string) string
+}
+
+// the analyzers that can be chosen for a library, by language
+//  add an analyzer here to support a new language
+var analyzers = map[string]analyzer{
+	"en":     englishAnalyzer{},
+	"simple": simpleAnalyzer{},
+}
+
+// the language used by libraries that haven't chosen one
+const defaultLanguage = "en"
+
+// get the analyzer for the language, using the default if the language
+//  is unknown
+func analyzerFor(language string) analyzer {
+	if a, found := analyzers[language]; found {
+		return a
+	}
+	return analyzers[defaultLanguage]
+}
+
+// get the analyzer for the language of the current library
+func (self *context) analyzer() analyzer {
+	return analyzerFor(self.l.Language)
+}
+
+// get the language of the analyzer used for the current library
+func (self *context) analyzerLanguage() string {
+	if _, found := analyzers[self.l.Language]; found {
+		return self.l.Language
+	}
+	return defaultLanguage
+}
+
+// break apart the text into keywords, add each keyword to the given map
+func addWords(a analyzer, text string, words map[string]bool) {
+	for _, word := range a.tokenize(text) {
+		if keyword := a.keyword(word); len(keyword) > 0 {
+			words[keyword] = false
+		}
+	}
+}
+
+// letters written with diacritics, and ligatures, mapped to the plain
+//  lower case letters they are folded to so that e.g. "jalapeno"
+//  matches "jalapeño"
+//  the standard library can't decompose letters (Unicode NFD), so
+//  only the Latin letters listed here lose their diacritics when they
+//  are written precomposed, e.g. "ǎ" and "ṭ" are kept as they are
+//  changing this table changes the keywords, it needs a new
+//  libraryVersion that runs migrateKeywords
+var foldedLetters = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ă': "a", 'ą': "a",
+	'æ': "ae",
+	'ç': "c", 'ć': "c", 'ĉ': "c", 'ċ': "c", 'č': "c",
+	'ď': "d", 'đ': "d", 'ð': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ĕ': "e", 'ė': "e", 'ę': "e", 'ě': "e",
+	'ĝ': "g", 'ğ': "g", 'ġ': "g", 'ģ': "g",
+	'ĥ': "h", 'ħ': "h",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ĩ': "i", 'ī': "i", 'ĭ': "i", 'į': "i", 'ı': "i",
+	'ĵ': "j",
+	'ķ': "k",
+	'ĺ': "l", 'ļ': "l", 'ľ': "l", 'ŀ': "l", 'ł': "l",
+	'ñ': "n", 'ń': "n", 'ņ': "n", 'ň': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o", 'ŏ': "o", 'ő': "o",
+	'œ': "oe",
+	'ŕ': "r", 'ŗ': "r", 'ř': "r",
+	'ś': "s", 'ŝ': "s", 'ş': "s", 'š': "s", 'ß': "ss",
+	'ţ': "t", 'ť': "t", 'ŧ': "t", 'þ': "th",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ũ': "u", 'ū': "u", 'ŭ': "u", 'ů': "u", 'ű': "u", 'ų': "u",
+	'ŵ': "w",
+	'ý': "y", 'ÿ': "y", 'ŷ': "y",
+	'ź': "z", 'ż': "z", 'ž': "z",
+}
+
+// check if the rune is a combining mark, e.g. from text where "é" is
+//  written as "e" followed by U+0301, these are dropped in every script
+func isCombiningMark(r rune) bool {
+	return unicode.Is(unicode.Mn, r)
+}
+
+// check if the rune is from a script written without spaces between
+//  words, each character of these is treated as a word
+func isUnsegmented(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana)
+}
+
+// fold the text to lower case without diacritics
+func foldText(text string) string {
+	folded := make([]rune, 0, len(text))
+	for _, r := range text {
+		if isCombiningMark(r) {
+			continue
+		}
+		r = unicode.ToLower(r)
+		if plain, found := foldedLetters[r]; found {
+			folded = append(folded, []rune(plain)...)
+		} else {
+			folded = append(folded, r)
+		}
+	}
+	return string(folded)
+}
+
+// a word of some text, with its position in the text
+type wordSpan struct {
+	// the folded, lower case word
+	word string
+	// the offsets of the first byte of the word in the text, and of
+	//  the byte after it
+	start, end int
+}
+
+// split text on spaces and punctuation into folded, lower case words
+//  characters of scripts without spaces are each their own word
+func wordSpans(text string) []wordSpan {
+	spans := make([]wordSpan, 0, 20)
+	word := make([]rune, 0, 20)
+	start := -1
+	// add the word being collected, which ends before the offset
+	addWord := func(end int) {
+		if start >= 0 {
+			spans = append(spans, wordSpan{string(word), start, end})
+			word = word[:0]
+			start = -1
+		}
+	}
+	for i, r := range text {
+		if isCombiningMark(r) {
+			continue
+		}
+		if unicode.IsSpace(r) || unicode.IsPunct(r) {
+			addWord(i)
+			continue
+		}
+		if isUnsegmented(r) {
+			addWord(i)
+			spans = append(spans, wordSpan{string(r), i, i + utf8.RuneLen(r)})
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+		word = append(word, []rune(foldText(string(r)))...)
+	}
+	addWord(len(text))
+	return spans
+}
+
+// split text into folded, lower case words, see wordSpans
+func splitWords(text string) []string {
+	spans := wordSpans(text)
+	words := make([]string, len(spans))
+	for i, _ := range spans {
+		words[i] = spans[i].word
+	}
+	return words
+}
+
+// analyzer that indexes every word as it is written
+//  for languages without their own analyzer
+type simpleAnalyzer struct{}
+
+func (self simpleAnalyzer) tokenize(text string) []string {
+	return splitWords(text)
+}
+
+func (self simpleAnalyzer) spans(text string) []wordSpan {
+	return wordSpans(text)
+}
+
+func (self simpleAnalyzer) keyword(word string) string {
+	// single letters aren't worth indexing
+	if len(word) < 2 {
+		return ""
+	}
+	return word
+}
+
+// analyzer for English, skips common words and indexes the stem of
+//  the rest so that e.g. "roasted" and "roasting" match "roast"
+type englishAnalyzer struct{}
+
+// words too common to be worth indexing
+var englishStopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true,
+	"at": true, "be": true, "but": true, "by": true, "for": true,
+	"from": true, "if": true, "in": true, "into": true, "is": true,
+	"it": true, "its": true, "of": true, "on": true, "or": true,
+	"so": true, "than": true, "that": true, "the": true, "then": true,
+	"there": true, "these": true, "this": true, "to": true, "was": true,
+	"were": true, "will": true, "with": true,
+}
+
+func (self englishAnalyzer) tokenize(text string) []string {
+	return splitWords(text)
+}
+
+func (self englishAnalyzer) spans(text string) []wordSpan {
+	return wordSpans(text)
+}
+
+func (self englishAnalyzer) keyword(word string) string {
+	if len(word) < 2 || englishStopWords[word] {
+		return ""
+	}
+	return porterStem(word)
+}
+
+// get the stem of the lower case English word using the Porter
+//  stemming algorithm, see http://tartarus.org/~martin/PorterStemmer/
+//  words with characters other than a-z are returned unchanged
+func porterStem(word string) string {
+	if len(word) <= 2 {
+		return word
+	}
+	for i := 0; i < len(word); i++ {
+		if word[i] < 'a' || word[i] > 'z' {
+			return word
+		}
+	}
+	z := &porterStemmer{b: []byte(word), k: len(word) - 1}
+	z.step1ab()
+	if z.k > 0 {
+		z.step1c()
+		z.step2()
+		z.step3()
+		z.step4()
+		z.step5()
+	}
+	return string(z.b[:z.k+1])
+}
+
+// state of the Porter stemmer, the word being stemmed is b[0..k]
+//  and j marks the end of the stem while checking a suffix
+type porterStemmer struct {
+	b    []byte
+	k, j int
+}
+
+// check if b[i] is a consonant
+func (self *porterStemmer) cons(i int) bool {
+	switch self.b[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		// y after a consonant acts as a vowel
+		if i == 0 {
+			return true
+		}
+		return !self.cons(i - 1)
+	}
+	return true
+}
+
+// measure the number of consonant sequences in b[0..j], that is n in
+//  [C](VC){n}[V]
+func (self *porterStemmer) m() int {
+	n := 0
+	i := 0
+	for {
+		if i > self.j {
+			return n
+		}
+		if !self.cons(i) {
+			break
+		}
+		i++
+	}
+	i++
+	for {
+		for {
+			if i > self.j {
+				return n
+			}
+			if self.cons(i) {
+				break
+			}
+			i++
+		}
+		i++
+		n++
+		for {
+			if i > self.j {
+				return n
+			}
+			if !self.cons(i) {
+				break
+			}
+			i++
+		}
+		i++
+	}
+}
+
+// check if b[0..j] contains a vowel
+func (self *porterStemmer) vowelInStem() bool {
+	for i := 0; i <= self.j; i++ {
+		if !self.cons(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// check if b[i-1..i] is a double consonant
+func (self *porterStemmer) doubleCons(i int) bool {
+	if i < 1 || self.b[i] != self.b[i-1] {
+		return false
+	}
+	return self.cons(i)
+}
+
+// check if b[i-2..i] is consonant-vowel-consonant and the last
+//  consonant isn't w, x or y, e.g. the end of "hop" but not "snow"
+func (self *porterStemmer) cvc(i int) bool {
+	if i < 2 || !self.cons(i) || self.cons(i-1) || !self.cons(i-2) {
+		return false
+	}
+	switch self.b[i] {
+	case 'w', 'x', 'y':
+		return false
+	}
+	return true
+}
+
+// check if b[0..k] ends with the suffix, setting j to the end of
+//  the stem before it
+func (self *porterStemmer) ends(suffix string) bool {
+	length := len(suffix)
+	if length > self.k+1 || string(self.b[self.k-length+1:self.k+1]) != suffix {
+		return false
+	}
+	self.j = self.k - length
+	return true
+}
+
+// replace b[j+1..k] with the text
+func (self *porterStemmer) setTo(text string) {
+	self.b = append(self.b[:self.j+1], text...)
+	self.k = self.j + len(text)
+}
+
+// replace the suffix found by ends with the text if the stem
+//  has a consonant sequence
+func (self *porterStemmer) replace(text string) {
+	if self.m() > 0 {
+		self.setTo(text)
+	}
+}
+
+// remove plurals and -ed or -ing, e.g. caresses -> caress,
+//  ponies -> poni, meetings -> meet, hopping -> hop
+func (self *porterStemmer) step1ab() {
+	if self.b[self.k] == 's' {
+		if self.ends("sses") {
+			self.k -= 2
+		} else if self.ends("ies") {
+			self.setTo("i")
+		} else if self.b[self.k-1] != 's' {
+			self.k--
+		}
+	}
+	if self.ends("eed") {
+		if self.m() > 0 {
+			self.k--
+		}
+	} else if (self.ends("ed") || self.ends("ing")) && self.vowelInStem() {
+		self.k = self.j
+		if self.ends("at") {
+			self.setTo("ate")
+		} else if self.ends("bl") {
+			self.setTo("ble")
+		} else if self.ends("iz") {
+			self.setTo("ize")
+		} else if self.doubleCons(self.k) {
+			self.k--
+			switch self.b[self.k] {
+			case 'l', 's', 'z':
+				self.k++
+			}
+		} else if self.m() == 1 && self.cvc(self.k) {
+			self.setTo("e")
+		}
+	}
+}
+
+// turn a final y into i when there is another vowel, e.g. happy -> happi
+func (self *porterStemmer) step1c() {
+	if self.ends("y") && self.vowelInStem() {
+		self.b[self.k] = 'i'
+	}
+}
+
+// the suffix replacements of step 2, by the next to last letter
+var porterStep2 = map[byte][][2]string{
+	'a': {{"ational", "ate"}, {"tional", "tion"}},
+	'c': {{"enci", "ence"}, {"anci", "ance"}},
+	'e': {{"izer", "ize"}},
+	'l': {{"bli", "ble"}, {"alli", "al"}, {"entli", "ent"}, {"eli", "e"}, {"ousli", "ous"}},
+	'o': {{"ization", "ize"}, {"ation", "ate"}, {"ator", "ate"}},
+	's': {{"alism", "al"}, {"iveness", "ive"}, {"fulness", "ful"}, {"ousness", "ous"}},
+	't': {{"aliti", "al"}, {"iviti", "ive"}, {"biliti", "ble"}},
+	'g': {{"logi", "log"}},
+}
+
+// the suffix replacements of step 3, by the last letter
+var porterStep3 = map[byte][][2]string{
+	'e': {{"icate", "ic"}, {"ative", ""}, {"alize", "al"}},
+	'i': {{"iciti", "ic"}},
+	'l': {{"ical", "ic"}, {"ful", ""}},
+	's': {{"ness", ""}},
+}
+
+// replace the first of the suffixes the word ends with
+func (self *porterStemmer) replaceSuffix(suffixes [][2]string) {
+	for _, suffix := range suffixes {
+		if self.ends(suffix[0]) {
+			self.replace(suffix[1])
+			return
+		}
+	}
+}
+
+// map double suffixes to single ones, e.g. -ization -> -ize
+func (self *porterStemmer) step2() {
+	self.replaceSuffix(porterStep2[self.b[self.k-1]])
+}
+
+// handle -ic-, -full, -ness etc.
+func (self *porterStemmer) step3() {
+	self.replaceSuffix(porterStep3[self.b[self.k]])
+}
+
+// the suffixes removed by step 4, by the next to last letter
+var porterStep4 = map[byte][]string{
+	'a': {"al"},
+	'c': {"ance", "ence"},
+	'e': {"er"},
+	'i': {"ic"},
+	'l': {"able", "ible"},
+	'n': {"ant", "ement", "ment", "ent"},
+	'o': {"ion", "ou"},
+	's': {"ism"},
+	't': {"ate", "iti"},
+	'u': {"ous"},
+	'v': {"ive"},
+	'z': {"ize"},
+}
+
+// remove -ant, -ence etc. from stems with two consonant sequences
+func (self *porterStemmer) step4() {
+	for _, suffix := range porterStep4[self.b[self.k-1]] {
+		if !self.ends(suffix) {
+			continue
+		}
+		// -ion is only removed after s or t
+		if suffix == "ion" && (self.j < 0 || (self.b[self.j] != 's' && self.b[self.j] != 't')) {
+			continue
+		}
+		if self.m() > 1 {
+			self.k = self.j
+		}
+		return
+	}
+}
+
+// remove a final -e and reduce a final -ll, e.g. rate -> rat, roll -> rol
+func (self *porterStemmer) step5() {
+	self.j = self.k
+	if self.b[self.k] == 'e' {
+		a := self.m()
+		if a > 1 || a == 1 && !self.cvc(self.k-1) {
+			self.k--
+		}
+	}
+	if self.b[self.k] == 'l' && self.doubleCons(self.k) && self.m() > 1 {
+		self.k--
+	}
+}
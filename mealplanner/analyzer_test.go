@@ -0,0 +1,153 @@
+package mealplanner
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPorterStem(t *testing.T) {
+	tests := []struct {
+		word string
+		want string
+	}{
+		// too short to stem
+		{"a", "a"},
+		{"is", "is"},
+		// not a-z, left as is
+		{"jalapeño", "jalapeño"},
+		{"7up", "7up"},
+		// step 1a
+		{"caresses", "caress"},
+		{"ponies", "poni"},
+		{"ties", "ti"},
+		{"caress", "caress"},
+		{"cats", "cat"},
+		// step 1b
+		{"feed", "feed"},
+		{"agreed", "agre"},
+		{"plastered", "plaster"},
+		{"bled", "bled"},
+		{"motoring", "motor"},
+		{"sing", "sing"},
+		{"conflated", "conflat"},
+		{"troubled", "troubl"},
+		{"sized", "size"},
+		{"hopping", "hop"},
+		{"tanned", "tan"},
+		{"falling", "fall"},
+		{"hissing", "hiss"},
+		{"fizzed", "fizz"},
+		{"failing", "fail"},
+		{"filing", "file"},
+		// step 1c
+		{"happy", "happi"},
+		{"sky", "sky"},
+		// step 2
+		{"relational", "relat"},
+		{"conditional", "condit"},
+		{"rational", "ration"},
+		{"digitizer", "digit"},
+		{"operator", "oper"},
+		// step 3
+		{"triplicate", "triplic"},
+		{"formative", "form"},
+		{"hopeful", "hope"},
+		{"goodness", "good"},
+		// step 4
+		{"revival", "reviv"},
+		{"allowance", "allow"},
+		{"adjustable", "adjust"},
+		{"adoption", "adopt"},
+		// step 5
+		{"probate", "probat"},
+		{"rate", "rate"},
+		{"cease", "ceas"},
+		{"controll", "control"},
+		{"roll", "roll"},
+		// cooking words that should match each other
+		{"roasted", "roast"},
+		{"roasting", "roast"},
+		{"roasts", "roast"},
+		{"cooking", "cook"},
+		{"cookies", "cooki"},
+		{"tomatoes", "tomato"},
+	}
+	for _, test := range tests {
+		if got := porterStem(test.word); got != test.want {
+			t.Errorf("porterStem(%q) = %q, want %q", test.word, got, test.want)
+		}
+	}
+}
+
+func TestEnglishKeyword(t *testing.T) {
+	a := englishAnalyzer{}
+	tests := []struct {
+		word string
+		want string
+	}{
+		{"the", ""},
+		{"x", ""},
+		{"grilled", "grill"},
+		{"peppers", "pepper"},
+	}
+	for _, test := range tests {
+		if got := a.keyword(test.word); got != test.want {
+			t.Errorf("keyword(%q) = %q, want %q", test.word, got, test.want)
+		}
+	}
+}
+
+func TestFoldText(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"", ""},
+		{"Chicken", "chicken"},
+		{"Jalapeño", "jalapeno"},
+		{"CRÈME BRÛLÉE", "creme brulee"},
+		// decomposed, e followed by a combining acute accent
+		{"cre\u0301me", "creme"},
+		// combining marks outside the Latin block
+		{"a\u20d7", "a"},
+		{"Smørrebrød", "smorrebrod"},
+		{"Œufs", "oeufs"},
+		{"Weißwurst", "weisswurst"},
+		{"Łódź", "lodz"},
+		// punctuation and other scripts are kept
+		{"mac & cheese", "mac & cheese"},
+		{"寿司", "寿司"},
+		// precomposed letters missing from foldedLetters are kept
+		{"ǎ", "ǎ"},
+	}
+	for _, test := range tests {
+		if got := foldText(test.text); got != test.want {
+			t.Errorf("foldText(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestWordSpans(t *testing.T) {
+	tests := []struct {
+		text string
+		want []wordSpan
+	}{
+		{"", []wordSpan{}},
+		{"  ", []wordSpan{}},
+		{"Fried rice", []wordSpan{{"fried", 0, 5}, {"rice", 6, 10}}},
+		{"mac&cheese!", []wordSpan{{"mac", 0, 3}, {"cheese", 4, 10}}},
+		// offsets are in bytes of the text, words are folded
+		{"Jalapeño poppers", []wordSpan{{"jalapeno", 0, 9}, {"poppers", 10, 17}}},
+		// a combining mark is part of the word, but not of its letters
+		{"cre\u0301me", []wordSpan{{"creme", 0, 7}}},
+		{"Œufs", []wordSpan{{"oeufs", 0, 5}}},
+		// each character of scripts without spaces is a word
+		{"寿司 rolls", []wordSpan{{"寿", 0, 3}, {"司", 3, 6}, {"rolls", 7, 12}}},
+	}
+	for _, test := range tests {
+		got := wordSpans(test.text)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("wordSpans(%q) = %v, want %v", test.text, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package mealplanner
+
+// suggestions of names for the user as they type, using the Prefix
+//  entities kept up to date alongside the keywords
+
+import (
+	"appengine"
+	"appengine/datastore"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// a name completing what the user has typed, sent to the client as JSON
+type completion struct {
+	// Dish, Ingredient or Tag
+	Kind string
+	// Id of the dish or ingredient, empty for tags
+	Id string
+	// the name or tag
+	Name string
+}
+
+// the kinds the client can ask for completions of
+var completionKinds = map[string]string{
+	"dish":       "Dish",
+	"ingredient": "Ingredient",
+	"tag":        "Tag",
+}
+
+// the number of completions returned if the client doesn't give a limit
+const defaultCompletionLimit = 10
+
+// the longest Word stored in a Prefix, in bytes
+//  longer prefixes are cut, users rarely type more than this
+const maxPrefixLength = 50
+
+// handler for completions, /suggest?q=<text>&kind=dish|ingredient|tag
+//  optional "limit" parameter sets how many names to return
+func completionHandler(c *context) {
+	if c.r.Method != "GET" {
+		check(ErrUnsupported)
+	}
+	kind, found := completionKinds[c.r.FormValue("kind")]
+	if !found {
+		check(ErrUnsupported)
+	}
+	limit := defaultCompletionLimit
+	if limitStr := c.r.FormValue("limit"); len(limitStr) > 0 {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		check(err)
+		if limit < 0 {
+			check(ErrUnsupported)
+		}
+	}
+	c.sendJSONNoCache(findCompletions(c, kind, c.r.FormValue("q"), limit))
+}
+
+// find the names of the kind that have a word starting with the text
+func findCompletions(c *context, kind string, text string, limit int) []completion {
+	completions := make([]completion, 0, limit)
+	text = foldText(strings.TrimSpace(text))
+	if len(text) == 0 || limit == 0 {
+		return completions
+	}
+	if len(text) > maxPrefixLength {
+		text = cutPrefix(text)
+	}
+	if kind == "Tag" {
+		return findTagCompletions(c, text, limit)
+	}
+	query := c.NewQuery("Prefix").Filter("Kind =", kind).
+		Filter("Word >=", text).Filter("Word <", text+"\ufffd").Order("Word")
+	iter := query.Run(c.c)
+	// a name with several words starting with the text is found more
+	//  than once
+	seen := make(map[string]bool)
+	prefix := &Prefix{}
+	for key, err := iter.Next(prefix); err != datastore.Done; key, err = iter.Next(prefix) {
+		check(err)
+		result := completion{Kind: kind, Id: key.Parent().Encode(), Name: prefix.Text}
+		if seen[result.Id] {
+			continue
+		}
+		seen[result.Id] = true
+		completions = append(completions, result)
+		if len(completions) == limit {
+			break
+		}
+	}
+	return completions
+}
+
+// find the tags with a word starting with the text
+//  every item with a tag has its own Prefix entities, so rather than
+//  reading all of them, each query skips past the Word of the tag found
+//  before
+//  a tag with several words starting with the text is found once per
+//  word, so the queries are capped at twice the limit
+func findTagCompletions(c *context, text string, limit int) []completion {
+	completions := make([]completion, 0, limit)
+	seen := make(map[string]bool)
+	query := c.NewQuery("Prefix").Filter("Kind =", "Tag").Filter("Word >=", text)
+	for queries := 0; len(completions) < limit && queries < 2*limit; queries++ {
+		prefixes := make([]Prefix, 0, 1)
+		_, err := query.Filter("Word <", text+"\ufffd").Order("Word").Limit(1).
+			GetAll(c.c, &prefixes)
+		check(err)
+		if len(prefixes) == 0 {
+			break
+		}
+		prefix := prefixes[0]
+		// found again through another of its words
+		if !seen[prefix.Text] {
+			seen[prefix.Text] = true
+			completions = append(completions, completion{Kind: "Tag", Name: prefix.Text})
+		}
+		query = c.NewQuery("Prefix").Filter("Kind =", "Tag").Filter("Word >", prefix.Word)
+	}
+	return completions
+}
+
+// add a Prefix for each word of the text to the map
+//  the prefixes are folded the same way as keywords, so "jalap"
+//  completes "Jalapeño"
+func addPrefixes(kind string, text string, prefixes map[Prefix]bool) {
+	folded := foldText(strings.TrimSpace(text))
+	wordStart := true
+	for i, rune := range folded {
+		if unicode.IsSpace(rune) || unicode.IsPunct(rune) {
+			wordStart = true
+			continue
+		}
+		if wordStart || isUnsegmented(rune) {
+			prefixes[Prefix{cutPrefix(folded[i:]), kind, text}] = false
+			wordStart = false
+		}
+	}
+}
+
+// add the prefixes of the tags of an item to the map passed in
+func addTagPrefixes(tags []string, prefixes map[Prefix]bool) {
+	for _, tag := range tags {
+		addPrefixes("Tag", tag, prefixes)
+	}
+}
+
+// cut the text to maxPrefixLength without splitting a character
+func cutPrefix(text string) string {
+	if len(text) <= maxPrefixLength {
+		return text
+	}
+	end := maxPrefixLength
+	for end > 0 && !utf8.RuneStart(text[end]) {
+		end--
+	}
+	return text[:end]
+}
+
+// deletes or adds prefix entries as children of the key if they
+//  are out of sync with the prefixes map
+func updatePrefixes(c appengine.Context, key *datastore.Key, prefixes map[Prefix]bool) {
+	query := datastore.NewQuery("Prefix").Ancestor(key)
+	iter := query.Run(c)
+	prefix := &Prefix{}
+	// collect the changes, to write them all at once
+	deleteKeys := make([]*datastore.Key, 0, 10)
+	for pkey, err := iter.Next(prefix); err == nil; pkey, err = iter.Next(prefix) {
+		if _, ok := prefixes[*prefix]; ok {
+			prefixes[*prefix] = true
+		} else {
+			// this prefix isn't here any more
+			deleteKeys = append(deleteKeys, pkey)
+		}
+	}
+	putKeys := make([]*datastore.Key, 0, len(prefixes))
+	putPrefixes := make([]*Prefix, 0, len(prefixes))
+	for prefix, exists := range prefixes {
+		if !exists {
+			newPrefix := prefix
+			putKeys = append(putKeys, datastore.NewIncompleteKey(c, "Prefix", key))
+			putPrefixes = append(putPrefixes, &newPrefix)
+		}
+	}
+	if len(deleteKeys) > 0 {
+		check(datastore.DeleteMulti(c, deleteKeys))
+	}
+	if len(putKeys) > 0 {
+		_, err := datastore.PutMulti(c, putKeys, putPrefixes)
+		check(err)
+	}
+}
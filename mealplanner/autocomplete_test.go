@@ -0,0 +1,92 @@
+package mealplanner
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAddPrefixes(t *testing.T) {
+	long := strings.Repeat("a", maxPrefixLength+10)
+	tests := []struct {
+		kind, text string
+		want       []Prefix
+	}{
+		{"Dish", "", []Prefix{}},
+		{"Dish", " - ", []Prefix{}},
+		{"Dish", "Pasta", []Prefix{{"pasta", "Dish", "Pasta"}}},
+		{"Dish", " Green  Curry ", []Prefix{
+			{"green  curry", "Dish", " Green  Curry "},
+			{"curry", "Dish", " Green  Curry "},
+		}},
+		// folded, so "jalap" completes it
+		{"Ingredient", "Jalapeño", []Prefix{{"jalapeno", "Ingredient", "Jalapeño"}}},
+		{"Tag", "cuisine/italian", []Prefix{
+			{"cuisine/italian", "Tag", "cuisine/italian"},
+			{"italian", "Tag", "cuisine/italian"},
+		}},
+		{"Dish", "mac-n-cheese", []Prefix{
+			{"mac-n-cheese", "Dish", "mac-n-cheese"},
+			{"n-cheese", "Dish", "mac-n-cheese"},
+			{"cheese", "Dish", "mac-n-cheese"},
+		}},
+		// unsegmented text has a prefix at every character
+		{"Dish", "麻婆豆腐", []Prefix{
+			{"麻婆豆腐", "Dish", "麻婆豆腐"},
+			{"婆豆腐", "Dish", "麻婆豆腐"},
+			{"豆腐", "Dish", "麻婆豆腐"},
+			{"腐", "Dish", "麻婆豆腐"},
+		}},
+		{"Dish", long, []Prefix{{long[:maxPrefixLength], "Dish", long}}},
+	}
+	for _, test := range tests {
+		prefixes := make(map[Prefix]bool)
+		addPrefixes(test.kind, test.text, prefixes)
+		want := make(map[Prefix]bool)
+		for _, prefix := range test.want {
+			want[prefix] = false
+		}
+		if !reflect.DeepEqual(prefixes, want) {
+			t.Errorf("addPrefixes(%q, %q) = %v, want %v", test.kind, test.text, prefixes, want)
+		}
+	}
+}
+
+func TestAddTagPrefixes(t *testing.T) {
+	prefixes := make(map[Prefix]bool)
+	addTagPrefixes([]string{"quick", "diet/vegan"}, prefixes)
+	want := map[Prefix]bool{
+		{"quick", "Tag", "quick"}:           false,
+		{"diet/vegan", "Tag", "diet/vegan"}: false,
+		{"vegan", "Tag", "diet/vegan"}:      false,
+	}
+	if !reflect.DeepEqual(prefixes, want) {
+		t.Errorf("addTagPrefixes = %v, want %v", prefixes, want)
+	}
+}
+
+func TestCutPrefix(t *testing.T) {
+	short := strings.Repeat("a", maxPrefixLength)
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"", ""},
+		{"pasta", "pasta"},
+		{short, short},
+		{short + "b", short},
+		// "é" is two bytes, straddling the limit
+		{short[1:] + "é", short[1:]},
+		{short[2:] + "é", short[2:] + "é"},
+		{short[2:] + "ée", short[2:] + "é"},
+		// "豆" is three bytes
+		{short[1:] + "豆", short[1:]},
+		{short[2:] + "豆", short[2:]},
+		{short[3:] + "豆", short[3:] + "豆"},
+	}
+	for _, test := range tests {
+		if got := cutPrefix(test.text); got != test.want {
+			t.Errorf("cutPrefix(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package mealplanner
+
+// search for dishes that can be made from the ingredients on hand
+
+import (
+	"appengine/datastore"
+	"sort"
+	"strings"
+)
+
+// ingredients left out when searching with IgnoreStaples
+//  ingredients tagged "staple" are also left out
+var stapleIngredients = map[string]bool{
+	"salt":          true,
+	"pepper":        true,
+	"black pepper":  true,
+	"water":         true,
+	"oil":           true,
+	"olive oil":     true,
+	"vegetable oil": true,
+	"canola oil":    true,
+	"butter":        true,
+	"sugar":         true,
+	"flour":         true,
+}
+
+// reference to an ingredient, sent to the client as JSON
+type ingredientRef struct {
+	// Id of the ingredient
+	Id string
+	// Name of the ingredient
+	Name string
+}
+
+// a dish that can be made, at least partly, from the ingredients on hand
+type cookableDish struct {
+	// Id of the dish
+	Dish string
+	// Name of the dish
+	Name string
+	// fraction of the dish's ingredients that are on hand
+	Coverage float32
+	// how many of the dish's ingredients are on hand
+	Have int
+	// how many ingredients the dish needs
+	Needed int
+	// the ingredients that aren't on hand
+	Missing []ingredientRef
+}
+
+// sort ingredient references by name
+type ingredientRefsByName []ingredientRef
+
+func (self ingredientRefsByName) Len() int {
+	return len(self)
+}
+func (self ingredientRefsByName) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+func (self ingredientRefsByName) Less(i, j int) bool {
+	return self[i].Name < self[j].Name
+}
+
+// sort dishes with the best coverage first, then the fewest missing
+type cookableByCoverage []*cookableDish
+
+func (self cookableByCoverage) Len() int {
+	return len(self)
+}
+func (self cookableByCoverage) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+func (self cookableByCoverage) Less(i, j int) bool {
+	if self[i].Coverage != self[j].Coverage {
+		return self[i].Coverage > self[j].Coverage
+	}
+	if len(self[i].Missing) != len(self[j].Missing) {
+		return len(self[i].Missing) < len(self[j].Missing)
+	}
+	return self[i].Name < self[j].Name
+}
+
+// rank the dishes by how many of their measured ingredients are in sp.Have
+//  if the search has tags or words, only matching dishes are ranked
+func findCookableDishes(c *context, sp *searchParams) []*cookableDish {
+	// get all the ingredients so we can match names and report what is missing
+	ingredients := make([]Ingredient, 0, 200)
+	ikeys, err := c.NewQuery("Ingredient").GetAll(c.c, &ingredients)
+	check(err)
+	names := make(map[string]string)
+	keysByName := make(map[string]string)
+	for i, _ := range ingredients {
+		id := ikeys[i].Encode()
+		names[id] = ingredients[i].Name
+		keysByName[strings.ToLower(strings.TrimSpace(ingredients[i].Name))] = id
+	}
+	// resolve what the cook has, each item can be an id or a name
+	have := make(map[string]bool)
+	for _, item := range sp.Have {
+		if _, found := names[item]; found {
+			have[item] = true
+		} else if id, found := keysByName[strings.ToLower(strings.TrimSpace(item))]; found {
+			have[id] = true
+		}
+	}
+	// find the staples to be left out
+	staples := make(map[string]bool)
+	if sp.IgnoreStaples {
+		for name, id := range keysByName {
+			if stapleIngredients[name] {
+				staples[id] = true
+			}
+		}
+		query := c.NewQuery("Ingredient").Filter("Tags =", "staple").KeysOnly()
+		keys, err := query.GetAll(c.c, nil)
+		check(err)
+		for _, key := range keys {
+			staples[key.Encode()] = true
+		}
+	}
+	// limit the dishes to those matching the rest of the search
+	var candidates map[string]uint
+	if sp.query() != nil || sp.filtered() {
+		candidates = runSearch(c, sp)["Dish"]
+		if candidates == nil {
+			candidates = make(map[string]uint)
+		}
+	}
+	// gather the ingredients each dish needs
+	mis := make([]MeasuredIngredient, 0, 1000)
+	mkeys, err := c.NewQuery("MeasuredIngredient").GetAll(c.c, &mis)
+	check(err)
+	needed := make(map[string]map[string]bool)
+	for i, _ := range mis {
+		if mis[i].Ingredient == nil {
+			continue
+		}
+		dishId := mkeys[i].Parent().Encode()
+		if candidates != nil {
+			if _, found := candidates[dishId]; !found {
+				continue
+			}
+		}
+		ingId := mis[i].Ingredient.Encode()
+		if staples[ingId] {
+			continue
+		}
+		if _, found := needed[dishId]; !found {
+			needed[dishId] = make(map[string]bool)
+		}
+		needed[dishId][ingId] = true
+	}
+	// rank the dishes that can use something on hand
+	ranked := cookableCoverage(needed, have, names)
+	// fill in the names, dropping dishes that no longer exist
+	dishKeys := make([]*datastore.Key, len(ranked))
+	for i, cookable := range ranked {
+		dishKeys[i], err = datastore.DecodeKey(cookable.Dish)
+		check(err)
+	}
+	dishes := make([]Dish, len(dishKeys))
+	errs := getMulti(c.c, dishKeys, dishes)
+	results := make([]*cookableDish, 0, len(ranked))
+	for i, cookable := range ranked {
+		if errs[i] == datastore.ErrNoSuchEntity {
+			continue
+		}
+		check(errs[i])
+		cookable.Name = dishes[i].Name
+		results = append(results, cookable)
+	}
+	sort.Sort(cookableByCoverage(results))
+	return results
+}
+
+// work out how much of each dish's ingredients (needed, by dish id)
+//  are on hand, leaving out dishes that can't use any of them
+//  the Names of the dishes aren't filled in, names gives the names of
+//  the ingredients
+func cookableCoverage(needed map[string]map[string]bool, have map[string]bool,
+	names map[string]string) []*cookableDish {
+	results := make([]*cookableDish, 0, len(needed))
+	for dishId, ingIds := range needed {
+		cookable := &cookableDish{
+			Dish:    dishId,
+			Needed:  len(ingIds),
+			Missing: make([]ingredientRef, 0, len(ingIds)),
+		}
+		for ingId, _ := range ingIds {
+			if have[ingId] {
+				cookable.Have++
+			} else {
+				cookable.Missing = append(cookable.Missing, ingredientRef{ingId, names[ingId]})
+			}
+		}
+		if cookable.Have == 0 {
+			continue
+		}
+		cookable.Coverage = float32(cookable.Have) / float32(cookable.Needed)
+		sort.Sort(ingredientRefsByName(cookable.Missing))
+		results = append(results, cookable)
+	}
+	return results
+}
@@ -0,0 +1,61 @@
+package mealplanner
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestCookableCoverage(t *testing.T) {
+	names := map[string]string{"r": "rice", "e": "egg", "s": "scallion", "t": "tofu", "c": "chicken"}
+	needed := map[string]map[string]bool{
+		"friedRice": {"r": true, "e": true, "s": true},
+		"stirFry":   {"t": true, "s": true},
+		"roast":     {"c": true},
+	}
+	have := map[string]bool{"r": true, "s": true}
+	got := cookableCoverage(needed, have, names)
+	sort.Sort(cookableByCoverage(got))
+	want := []*cookableDish{
+		{Dish: "friedRice", Coverage: 2.0 / 3, Have: 2, Needed: 3,
+			Missing: []ingredientRef{{"e", "egg"}}},
+		{Dish: "stirFry", Coverage: 0.5, Have: 1, Needed: 2,
+			Missing: []ingredientRef{{"t", "tofu"}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("cookableCoverage() = %v, want %v", got, want)
+	}
+	// nothing on hand, nothing to cook
+	if got := cookableCoverage(needed, map[string]bool{}, names); len(got) != 0 {
+		t.Errorf("cookableCoverage() with nothing on hand = %v, want none", got)
+	}
+	// the missing ingredients are listed by name
+	got = cookableCoverage(map[string]map[string]bool{
+		"omelet": {"e": true, "t": true, "c": true, "s": true}}, have, names)
+	missing := []ingredientRef{{"c", "chicken"}, {"e", "egg"}, {"t", "tofu"}}
+	if len(got) != 1 || !reflect.DeepEqual(got[0].Missing, missing) {
+		t.Errorf("cookableCoverage() missing = %v, want %v", got, missing)
+	}
+}
+
+func TestCookableByCoverage(t *testing.T) {
+	missing := func(n int) []ingredientRef {
+		return make([]ingredientRef, n)
+	}
+	dishes := []*cookableDish{
+		{Name: "d", Coverage: 0.5, Missing: missing(2)},
+		{Name: "c", Coverage: 0.5, Missing: missing(1)},
+		{Name: "b", Coverage: 1},
+		{Name: "e", Coverage: 0.25, Missing: missing(3)},
+		{Name: "a", Coverage: 0.5, Missing: missing(1)},
+	}
+	sort.Sort(cookableByCoverage(dishes))
+	order := ""
+	for _, dish := range dishes {
+		order += dish.Name
+	}
+	// best coverage, then fewest missing, then by name
+	if order != "bacde" {
+		t.Errorf("cookableByCoverage order = %s, want bacde", order)
+	}
+}
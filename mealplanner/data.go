@@ -13,12 +13,16 @@ type Library struct {
 	// OwnerId is the user id of the user that owns this library
 	OwnerId string
 	// what version of the datastructure is used (for forward compatibility)
+	//  libraries older than libraryVersion are upgraded by migrateLibrary
 	Version int
 	// The name of the library
 	Name string
 	// which library does the owner of this library want to see
 	//  nil means the user's own library
 	UserPreferredLibrary string
+	// the language of the text in the library, chooses the analyzer
+	//  used for keywords, empty for defaultLanguage
+	Language string
 }
 
 // permission granting access to another user
@@ -49,6 +53,31 @@ type Ided interface {
 	SetID(string)
 }
 
+// interface of the items with tags and keywords: dishes, ingredients
+//  and menus
+type taggedItem interface {
+	Ided
+	tags() []string
+	setTags(tags []string)
+	keywords() []string
+	setKeywords(keywords []string)
+	setIndexStatus(status string)
+}
+
+// create an empty item of a kind with tags, panics for other kinds
+func newTaggedItem(kind string) taggedItem {
+	switch kind {
+	case "Dish":
+		return &Dish{}
+	case "Ingredient":
+		return &Ingredient{}
+	case "Menu":
+		return &Menu{}
+	}
+	check(ErrUnsupported)
+	return nil
+}
+
 // Fields describing a dish
 // Child of Library
 type Dish struct {
@@ -75,6 +104,19 @@ type Dish struct {
 	ServingsVeggies float32
 	// free-form text from the user
 	Text string
+	// the tags the user gave the dish, managed through /dish/<id>/tags/
+	Tags []string
+	// the keywords indexed for the dish, see updateDishKeywords
+	//  rebuilt rather than sent to the client or kept in backups
+	Keywords []string `json:"-"`
+	// whether the keywords have caught up with changes to the dish,
+	//  indexPending or indexDone, empty for dishes saved before
+	//  keywords were indexed in the background
+	IndexStatus string
+	// how many times the dish has been cooked, and when it was last
+	//  cooked, recorded by POST /dish/<id>/cooked
+	CookedCount int
+	LastCooked  time.Time
 }
 
 // Record linking a dish to ingredients in the dish
@@ -103,6 +145,11 @@ type Ingredient struct {
 	Category string
 	// Is this vegan, vegetarian, or from an animal
 	Source string
+	// the tags and keywords of the ingredient, see Dish
+	Tags     []string
+	Keywords []string `json:"-"`
+	// whether the keywords have caught up with changes, see Dish
+	IndexStatus string
 }
 
 // Collection of dishes to be presented as a menu
@@ -112,20 +159,76 @@ type Menu struct {
 	Id string
 	// Name of the menu
 	Name string
-	// List of dishes in this menu
+	// The dishes in this menu
+	Items []MenuItem
+	// List of dishes in menus saved before Items were added
+	//  migrateMenuItems moves these into Items
 	Dishes []*datastore.Key
+	// the tags and keywords of the menu, see Dish
+	Tags     []string
+	Keywords []string `json:"-"`
+	// whether the keywords have caught up with changes, see Dish
+	IndexStatus string
+}
+
+// A dish as it appears in a menu
+// Stored as part of a Menu
+type MenuItem struct {
+	// key of the dish
+	Dish *datastore.Key
+	// how many batches of the dish to make (e.g. 2 for a double batch)
+	//  0 is treated the same as 1
+	Servings float32
+	// the course the dish is served in (e.g. "Soup", "Main")
+	Course string
+	// the order in which the dish is served within the menu
+	Position int
+	// free-form note from the user
+	Note string
+}
+
+// Targets used to judge whether a menu is balanced
+// Child of Library, stored with the key name "menu"
+type MenuTargets struct {
+	// how many servings of carbohydrates a balanced menu has
+	ServingsCarb float32
+	// how many servings of protein a balanced menu has
+	ServingsProtein float32
+	// how many servings of veggetables a balanced menu has
+	ServingsVeggies float32
+	// how far from a target (as a fraction of the target) a menu can be
+	//  before it is considered unbalanced
+	Tolerance float32
+	// the DishTypes a balanced menu should include
+	DishTypes []string
 }
 
 // Simple string type, used for Tags and Keyword
-// Child of Ingredient or Dish or Menu
+// Child of Ingredient or Dish or Menu in libraries saved before version 3,
+//  which keep them in the Tags and Keywords of the item instead
+//  the client still reads and writes tags as Words, see tagsHandler
 type Word struct {
 	// Id -- used to hold datastore key in JSON for the browser, stored value isn't used
+	//  the id of a tag is made from its text by tagID
 	Id string
 	// Text for this item
 	Word string
 }
 
-// Link between two dishes (should have two entries, one under each dish)
+// The start of a name or tag, used to suggest names as the user types
+//  one is stored for each word in the name, running to the end of the name
+// Child of Ingredient or Dish
+type Prefix struct {
+	// lower case text from the start of a word to the end of the name or tag
+	Word string
+	// Dish or Ingredient for the name of the parent, Tag for one of its tags
+	Kind string
+	// the name or tag as the user wrote it
+	Text string
+}
+
+// Link between two dishes (has a twin under the other dish unless the
+//  PairingType only goes one way)
 //  presents them as suggestions to go together, or as an alternative
 // Child of Dish
 type Pairing struct {
@@ -133,8 +236,25 @@ type Pairing struct {
 	Id string
 	// Key of the dish being suggested
 	Other *datastore.Key
-	// Description of the suggestion ("Recommended" or "Alternative")
+	// Name of the PairingType of the suggestion (e.g. "Together" or "Sauce for")
 	Description string
+	// free-form notes from the user
+	Notes string
+	// how strongly the dishes go together (1-5), 0 if not given
+	Strength int
+}
+
+// A kind of pairing that can be made between dishes
+// Child of Library
+type PairingType struct {
+	// Id -- used to hold datastore key in JSON for the browser, stored value isn't used
+	Id string
+	// Name of the type, stored as the Description of pairings
+	Name string
+	// Name of the type used for the twin pairing under the other dish
+	//  the same as Name for symmetric types (e.g. "Alternative")
+	//  empty if the pairing only goes one way
+	Inverse string
 }
 
 // get the owner of the library
@@ -147,6 +267,43 @@ func (self *Library) SetOwner(o string) {
 	self.OwnerId = o
 }
 
+// how many batches of the dish the item calls for
+func (self *MenuItem) Multiplier() float32 {
+	if self.Servings <= 0 {
+		return 1
+	}
+	return self.Servings
+}
+
+// move any dishes listed in the old Dishes field into Items
+// returns true if the menu was changed
+func (self *Menu) migrateDishes() bool {
+	if len(self.Dishes) == 0 {
+		return false
+	}
+	for _, dishKey := range self.Dishes {
+		self.Items = append(self.Items, MenuItem{
+			Dish:     dishKey,
+			Servings: 1,
+			Position: len(self.Items),
+		})
+	}
+	self.Dishes = nil
+	return true
+}
+
+// A search saved under a name, evaluated each time it is used
+//  so it acts as a collection of the dishes that currently match
+// Child of Library
+type SavedSearch struct {
+	// Id -- used to hold datastore key in JSON for the browser, stored value isn't used
+	Id string
+	// Name of the search, e.g. "Quick weeknight vegetarian"
+	Name string
+	// the searchParams, as JSON
+	Params string
+}
+
 // Methods implementing the Ided interface
 func (self *Dish) ID() string {
 	return self.Id
@@ -189,3 +346,66 @@ func (self *Pairing) ID() string {
 func (self *Pairing) SetID(id string) {
 	self.Id = id
 }
+
+func (self *SavedSearch) ID() string {
+	return self.Id
+}
+func (self *SavedSearch) SetID(id string) {
+	self.Id = id
+}
+
+func (self *PairingType) ID() string {
+	return self.Id
+}
+func (self *PairingType) SetID(id string) {
+	self.Id = id
+}
+
+// Methods implementing the taggedItem interface
+func (self *Dish) tags() []string {
+	return self.Tags
+}
+func (self *Dish) setTags(tags []string) {
+	self.Tags = tags
+}
+func (self *Dish) keywords() []string {
+	return self.Keywords
+}
+func (self *Dish) setKeywords(keywords []string) {
+	self.Keywords = keywords
+}
+func (self *Dish) setIndexStatus(status string) {
+	self.IndexStatus = status
+}
+
+func (self *Ingredient) tags() []string {
+	return self.Tags
+}
+func (self *Ingredient) setTags(tags []string) {
+	self.Tags = tags
+}
+func (self *Ingredient) keywords() []string {
+	return self.Keywords
+}
+func (self *Ingredient) setKeywords(keywords []string) {
+	self.Keywords = keywords
+}
+func (self *Ingredient) setIndexStatus(status string) {
+	self.IndexStatus = status
+}
+
+func (self *Menu) tags() []string {
+	return self.Tags
+}
+func (self *Menu) setTags(tags []string) {
+	self.Tags = tags
+}
+func (self *Menu) keywords() []string {
+	return self.Keywords
+}
+func (self *Menu) setKeywords(keywords []string) {
+	self.Keywords = keywords
+}
+func (self *Menu) setIndexStatus(status string) {
+	self.IndexStatus = status
+}
@@ -0,0 +1,77 @@
+package mealplanner
+
+import (
+	"appengine/datastore"
+	"testing"
+)
+
+func TestMenuItemMultiplier(t *testing.T) {
+	tests := []struct {
+		servings float32
+		want     float32
+	}{
+		// items saved before servings were recorded count once
+		{0, 1},
+		{-2, 1},
+		{1, 1},
+		{0.5, 0.5},
+		{3, 3},
+	}
+	for _, test := range tests {
+		item := &MenuItem{Servings: test.servings}
+		if got := item.Multiplier(); got != test.want {
+			t.Errorf("Multiplier() with Servings %v = %v, want %v", test.servings, got, test.want)
+		}
+	}
+}
+
+func TestMigrateDishes(t *testing.T) {
+	soup := &datastore.Key{}
+	bread := &datastore.Key{}
+	salad := &datastore.Key{}
+	tests := []struct {
+		name    string
+		menu    Menu
+		changed bool
+		// the dish of each item after the migration, nil for items
+		//  whose dish was missing from the backup
+		want []*datastore.Key
+	}{
+		{"empty", Menu{}, false, []*datastore.Key{}},
+		{"items only", Menu{Items: []MenuItem{{Dish: soup, Servings: 2}}},
+			false, []*datastore.Key{soup}},
+		{"dishes only", Menu{Dishes: []*datastore.Key{soup, bread}},
+			true, []*datastore.Key{soup, bread}},
+		{"dishes after items", Menu{Items: []MenuItem{{Dish: salad}},
+			Dishes: []*datastore.Key{soup, bread}},
+			true, []*datastore.Key{salad, soup, bread}},
+		// the import skips items without a dish, the migration keeps them
+		{"missing dish", Menu{Dishes: []*datastore.Key{soup, nil, bread}},
+			true, []*datastore.Key{soup, nil, bread}},
+	}
+	for _, test := range tests {
+		menu := test.menu
+		if changed := menu.migrateDishes(); changed != test.changed {
+			t.Errorf("%s: migrateDishes() = %v, want %v", test.name, changed, test.changed)
+		}
+		if menu.Dishes != nil {
+			t.Errorf("%s: Dishes = %v, want nil", test.name, menu.Dishes)
+		}
+		if len(menu.Items) != len(test.want) {
+			t.Errorf("%s: %d items, want %d", test.name, len(menu.Items), len(test.want))
+			continue
+		}
+		for i, item := range menu.Items {
+			if item.Dish != test.want[i] {
+				t.Errorf("%s: item %d has the wrong dish", test.name, i)
+			}
+			// the migrated dishes are served in order, once each
+			if test.changed && i >= len(test.menu.Items) {
+				if item.Position != i || item.Servings != 1 {
+					t.Errorf("%s: item %d Position %d Servings %v, want %d 1",
+						test.name, i, item.Position, item.Servings, i)
+				}
+			}
+		}
+	}
+}
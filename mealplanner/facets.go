@@ -0,0 +1,247 @@
+package mealplanner
+
+// counts of the dishes and ingredients by the values of their fields,
+//  used to browse the library by drilling down into those values
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// the tags under this parent describe diets, e.g. diet/low-carb
+const dietTag = "diet"
+
+// tags for diets the client adds to dishes on its own, from their
+//  ingredients, these aren't under dietTag
+var plainDietTags = []string{"Vegan", "Vegetarian"}
+
+// the time bucket of dishes without PrepTimeMinutes or CookTimeMinutes
+const unknownTime = "unknown"
+
+// a bucket of PrepTimeMinutes + CookTimeMinutes, from Min to Max minutes
+type timeBucket struct {
+	Label string
+	Min   int
+	// 0 for no upper limit
+	Max int
+}
+
+// the buckets dishes are counted in by total time
+var timeBuckets = []timeBucket{
+	{"0-15", 1, 15},
+	{"16-30", 16, 30},
+	{"31-60", 31, 60},
+	{"61-120", 61, 120},
+	{"120+", 121, 0},
+}
+
+// the number of items with a value, sent to the client as JSON
+type facetCount struct {
+	Value string
+	Count int
+}
+
+// sort facet counts with the most used values first, then by value
+type facetCountsByCount []*facetCount
+
+func (self facetCountsByCount) Len() int {
+	return len(self)
+}
+func (self facetCountsByCount) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+func (self facetCountsByCount) Less(i, j int) bool {
+	if self[i].Count != self[j].Count {
+		return self[i].Count > self[j].Count
+	}
+	return self[i].Value < self[j].Value
+}
+
+// counts of the values of the fields, sent to the client as JSON
+type facets struct {
+	// the number of dishes and ingredients counted
+	Dishes      int
+	Ingredients int
+	// dishes by DishType
+	DishType []*facetCount
+	// dishes by Rating, "0" for unrated, in order of the rating
+	Rating []*facetCount
+	// dishes by the timeBuckets their total time is in, in order of time
+	TotalMinutes []*facetCount
+	// dishes by their diet tags, e.g. diet/low-carb or Vegan, see isDietTag
+	Diet []*facetCount
+	// dishes by tag, a dish with a tag also counts for its parents
+	Tags []*facetCount
+	// ingredients by Category
+	Category []*facetCount
+	// ingredients by Source
+	Source []*facetCount
+}
+
+// counts of values, with the order the values were first seen
+type facetCounter struct {
+	counts map[string]*facetCount
+	order  []*facetCount
+}
+
+func newFacetCounter() *facetCounter {
+	return &facetCounter{make(map[string]*facetCount), make([]*facetCount, 0, 10)}
+}
+
+// count an item with the value
+func (self *facetCounter) add(value string) {
+	count, found := self.counts[value]
+	if !found {
+		count = &facetCount{Value: value}
+		self.counts[value] = count
+		self.order = append(self.order, count)
+	}
+	count.Count++
+}
+
+// the counts, with the most used values first
+func (self *facetCounter) byCount() []*facetCount {
+	sort.Sort(facetCountsByCount(self.order))
+	return self.order
+}
+
+// the counts, in the order of the values given, leaving out values
+//  that weren't seen
+func (self *facetCounter) inOrder(values []string) []*facetCount {
+	list := make([]*facetCount, 0, len(values))
+	for _, value := range values {
+		if count, found := self.counts[value]; found {
+			list = append(list, count)
+		}
+	}
+	return list
+}
+
+// check if the tag is for a diet, under dietTag or one of plainDietTags
+func isDietTag(tag string) bool {
+	if isTagOrChild(tag, dietTag) {
+		return tag != dietTag
+	}
+	return hasTag(plainDietTags, tag)
+}
+
+// get the tags that put a dish on the diet, e.g. diet/vegan and Vegan
+//  for the diet vegan, Vegan or diet/vegan
+//  a diet of just dietTag gives the tags of every diet
+func dietTags(diet string) []string {
+	diet = normalizeTag(diet)
+	if isTagOrChild(diet, dietTag) {
+		diet = strings.TrimPrefix(diet[len(dietTag):], tagSeparator)
+	}
+	if len(diet) == 0 {
+		return append([]string{dietTag}, plainDietTags...)
+	}
+	tags := []string{dietTag + tagSeparator + diet}
+	for _, plain := range plainDietTags {
+		if strings.EqualFold(plain, diet) {
+			tags = append(tags, plain)
+		}
+	}
+	return tags
+}
+
+// get the label of the timeBucket for the minutes
+func timeBucketFor(minutes int) string {
+	if minutes <= 0 {
+		return unknownTime
+	}
+	for _, bucket := range timeBuckets {
+		if minutes >= bucket.Min && (bucket.Max == 0 || minutes <= bucket.Max) {
+			return bucket.Label
+		}
+	}
+	return unknownTime
+}
+
+// handler for the counts of the values of the fields of the library
+//  GET /facets counts every dish and ingredient
+//  POST /facets counts those found by the searchParams sent as JSON
+// like /search, POST is used for a read
+func facetsHandler(c *context) {
+	switch c.r.Method {
+	case "GET":
+		c.sendJSONNoCache(countFacets(c, nil))
+	case "POST":
+		sp := searchParams{}
+		readJSON(c.r, &sp)
+		var results searchResults
+		if sp.query() != nil || sp.filtered() {
+			results = runSearch(c, &sp)
+		}
+		c.sendJSONNoCache(countFacets(c, results))
+	default:
+		check(ErrUnsupported)
+	}
+}
+
+// count the dishes and ingredients in the results by their fields,
+//  or every one in the library if results is nil
+func countFacets(c *context, results searchResults) *facets {
+	f := &facets{}
+	dishTypes := newFacetCounter()
+	ratings := newFacetCounter()
+	times := newFacetCounter()
+	diets := newFacetCounter()
+	tags := newFacetCounter()
+	dishes := make([]Dish, 0, 100)
+	keys, err := c.NewQuery("Dish").GetAll(c.c, &dishes)
+	check(err)
+	for i, _ := range dishes {
+		if _, found := results["Dish"][keys[i].Encode()]; results != nil && !found {
+			continue
+		}
+		dish := &dishes[i]
+		f.Dishes++
+		dishTypes.add(dish.DishType)
+		ratings.add(strconv.Itoa(dish.Rating))
+		times.add(timeBucketFor(dish.PrepTimeMinutes + dish.CookTimeMinutes))
+		// count the tags and their parents once each
+		counted := make(map[string]bool)
+		for _, tag := range dish.Tags {
+			parts := strings.Split(tag, tagSeparator)
+			for j, _ := range parts {
+				parent := strings.Join(parts[:j+1], tagSeparator)
+				if !counted[parent] {
+					counted[parent] = true
+					tags.add(parent)
+				}
+			}
+			if isDietTag(tag) {
+				diets.add(tag)
+			}
+		}
+	}
+	f.DishType = dishTypes.byCount()
+	f.Rating = ratings.inOrder([]string{"0", "1", "2", "3", "4", "5"})
+	labels := make([]string, 0, len(timeBuckets)+1)
+	labels = append(labels, unknownTime)
+	for _, bucket := range timeBuckets {
+		labels = append(labels, bucket.Label)
+	}
+	f.TotalMinutes = times.inOrder(labels)
+	f.Diet = diets.byCount()
+	f.Tags = tags.byCount()
+
+	categories := newFacetCounter()
+	sources := newFacetCounter()
+	ings := make([]Ingredient, 0, 100)
+	keys, err = c.NewQuery("Ingredient").GetAll(c.c, &ings)
+	check(err)
+	for i, _ := range ings {
+		if _, found := results["Ingredient"][keys[i].Encode()]; results != nil && !found {
+			continue
+		}
+		f.Ingredients++
+		categories.add(ings[i].Category)
+		sources.add(ings[i].Source)
+	}
+	f.Category = categories.byCount()
+	f.Source = sources.byCount()
+	return f
+}
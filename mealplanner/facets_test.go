@@ -0,0 +1,69 @@
+package mealplanner
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTimeBucketFor(t *testing.T) {
+	tests := []struct {
+		minutes int
+		want    string
+	}{
+		{-5, unknownTime},
+		{0, unknownTime},
+		{1, "0-15"},
+		{15, "0-15"},
+		{16, "16-30"},
+		{60, "31-60"},
+		{120, "61-120"},
+		{121, "120+"},
+		{600, "120+"},
+	}
+	for _, test := range tests {
+		if got := timeBucketFor(test.minutes); got != test.want {
+			t.Errorf("timeBucketFor(%d) = %q, want %q", test.minutes, got, test.want)
+		}
+	}
+}
+
+func TestIsDietTag(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want bool
+	}{
+		{"diet/low-carb", true},
+		{"diet/vegan/raw", true},
+		{"Vegan", true},
+		{"Vegetarian", true},
+		{"diet", false},
+		{"dietary", false},
+		{"vegan", false},
+		{"quick", false},
+	}
+	for _, test := range tests {
+		if got := isDietTag(test.tag); got != test.want {
+			t.Errorf("isDietTag(%q) = %v, want %v", test.tag, got, test.want)
+		}
+	}
+}
+
+func TestDietTags(t *testing.T) {
+	tests := []struct {
+		diet string
+		want []string
+	}{
+		{"low-carb", []string{"diet/low-carb"}},
+		{"diet/low-carb", []string{"diet/low-carb"}},
+		{"vegan", []string{"diet/vegan", "Vegan"}},
+		{"Vegetarian", []string{"diet/Vegetarian", "Vegetarian"}},
+		{" diet / vegan ", []string{"diet/vegan", "Vegan"}},
+		{"diet", []string{"diet", "Vegan", "Vegetarian"}},
+		{"", []string{"diet", "Vegan", "Vegetarian"}},
+	}
+	for _, test := range tests {
+		if got := dietTags(test.diet); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("dietTags(%q) = %v, want %v", test.diet, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package mealplanner
+
+// filters on the fields of the items found by a search
+
+// range of servings a dish may have
+type servingRange struct {
+	// smallest number of servings, nil for no lower limit
+	Min *float32
+	// largest number of servings, nil for no upper limit
+	Max *float32
+}
+
+// check if the servings are within the range, a nil range
+//  contains everything
+func (self *servingRange) contains(servings float32) bool {
+	if self == nil {
+		return true
+	}
+	if self.Min != nil && servings < *self.Min {
+		return false
+	}
+	if self.Max != nil && servings > *self.Max {
+		return false
+	}
+	return true
+}
+
+// check if any of the filters on Dish fields are set
+func (self *searchParams) filtersDishes() bool {
+	return len(self.DishType) > 0 || self.MinRating > 0 || self.Unrated ||
+		self.MaxTotalMinutes > 0 || self.ServingsCarb != nil ||
+		self.ServingsProtein != nil || self.ServingsVeggies != nil
+}
+
+// check if any of the filters on Ingredient fields are set
+func (self *searchParams) filtersIngredients() bool {
+	return len(self.IngredientCategory) > 0 || len(self.IngredientSource) > 0
+}
+
+// check if any filters are set
+func (self *searchParams) filtered() bool {
+	return self.filtersDishes() || self.filtersIngredients()
+}
+
+// check if the dish passes the filters on Dish fields
+func (self *searchParams) matchDish(dish *Dish) bool {
+	if len(self.DishType) > 0 && dish.DishType != self.DishType {
+		return false
+	}
+	if dish.Rating < self.MinRating || (self.Unrated && dish.Rating > 0) {
+		return false
+	}
+	if self.MaxTotalMinutes > 0 &&
+		dish.PrepTimeMinutes+dish.CookTimeMinutes > self.MaxTotalMinutes {
+		return false
+	}
+	return self.ServingsCarb.contains(dish.ServingsCarb) &&
+		self.ServingsProtein.contains(dish.ServingsProtein) &&
+		self.ServingsVeggies.contains(dish.ServingsVeggies)
+}
+
+// check if the items from source pass the filter on Source, vegan
+//  items are vegetarian too
+func sourceMatches(filter, source string) bool {
+	return source == filter || (filter == "Vegetarian" && source == "Vegan")
+}
+
+// get the Source of a dish made from the ingredients, like the client
+//  works it out: Vegan if they all are, Vegetarian if they are all
+//  Vegan or Vegetarian, otherwise Animal
+//  empty if there are no ingredients
+func dishSource(ings []*Ingredient) string {
+	if len(ings) == 0 {
+		return ""
+	}
+	source := "Vegan"
+	for _, ing := range ings {
+		switch ing.Source {
+		case "Vegan":
+		case "Vegetarian":
+			source = "Vegetarian"
+		default:
+			return "Animal"
+		}
+	}
+	return source
+}
+
+// check if the ingredient passes the filters on Ingredient fields
+func (self *searchParams) matchIngredient(ing *Ingredient) bool {
+	if len(self.IngredientCategory) > 0 && ing.Category != self.IngredientCategory {
+		return false
+	}
+	if len(self.IngredientSource) > 0 && !sourceMatches(self.IngredientSource, ing.Source) {
+		return false
+	}
+	return true
+}
+
+// check if a dish made from the ingredients passes the filters on
+//  Ingredient fields: one of the ingredients must be in the
+//  IngredientCategory, and the dishSource must match IngredientSource
+func (self *searchParams) matchDishIngredients(ings []*Ingredient) bool {
+	if len(self.IngredientCategory) > 0 {
+		found := false
+		for _, ing := range ings {
+			if ing.Category == self.IngredientCategory {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(self.IngredientSource) > 0 && !sourceMatches(self.IngredientSource, dishSource(ings)) {
+		return false
+	}
+	return true
+}
+
+// keep only the results that pass the filters
+//  dishes must pass the filters on Dish fields, and the filters on
+//  Ingredient fields through their ingredients, see matchDishIngredients
+//  ingredients are only kept if no filters on Dish fields are set,
+//  e.g. a DishType filter returns only dishes
+func (self *searchParams) filter(c *context, results searchResults) searchResults {
+	if self.MinRating < 0 || self.MaxTotalMinutes < 0 {
+		check(ErrBadQuery)
+	}
+	filtered := make(searchResults)
+	// the ingredients by id, and the ingredients of each dish by its id
+	var ingredients map[string]*Ingredient
+	var dishIngredients map[string][]*Ingredient
+	if self.filtersIngredients() {
+		ings := make([]Ingredient, 0, 100)
+		keys, err := c.NewQuery("Ingredient").GetAll(c.c, &ings)
+		check(err)
+		ingredients = make(map[string]*Ingredient, len(ings))
+		for i, _ := range ings {
+			ingredients[keys[i].Encode()] = &ings[i]
+		}
+		if len(results["Dish"]) > 0 {
+			mis := make([]MeasuredIngredient, 0, 1000)
+			miKeys, err := c.NewQuery("MeasuredIngredient").GetAll(c.c, &mis)
+			check(err)
+			dishIngredients = make(map[string][]*Ingredient)
+			for i, _ := range mis {
+				if mis[i].Ingredient == nil {
+					continue
+				}
+				dishId := miKeys[i].Parent().Encode()
+				if ing, found := ingredients[mis[i].Ingredient.Encode()]; found {
+					dishIngredients[dishId] = append(dishIngredients[dishId], ing)
+				}
+			}
+		}
+	}
+	if len(results["Dish"]) > 0 {
+		dishes := make([]Dish, 0, 100)
+		keys, err := c.NewQuery("Dish").GetAll(c.c, &dishes)
+		check(err)
+		for i, _ := range dishes {
+			id := keys[i].Encode()
+			count, found := results["Dish"][id]
+			if !found || !self.matchDish(&dishes[i]) {
+				continue
+			}
+			if self.filtersIngredients() && !self.matchDishIngredients(dishIngredients[id]) {
+				continue
+			}
+			filtered.add("Dish", id, count)
+		}
+	}
+	if self.filtersIngredients() && !self.filtersDishes() {
+		for id, count := range results["Ingredient"] {
+			if ing, found := ingredients[id]; found && self.matchIngredient(ing) {
+				filtered.add("Ingredient", id, count)
+			}
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,124 @@
+package mealplanner
+
+import (
+	"testing"
+)
+
+func servings(n float32) *float32 {
+	return &n
+}
+
+func TestServingRangeContains(t *testing.T) {
+	tests := []struct {
+		r        *servingRange
+		servings float32
+		want     bool
+	}{
+		{nil, 3, true},
+		{&servingRange{}, 0, true},
+		{&servingRange{Min: servings(1)}, 1, true},
+		{&servingRange{Min: servings(1)}, 0.5, false},
+		{&servingRange{Max: servings(2)}, 2, true},
+		{&servingRange{Max: servings(2)}, 2.5, false},
+		{&servingRange{Min: servings(1), Max: servings(2)}, 1.5, true},
+		{&servingRange{Min: servings(1), Max: servings(2)}, 3, false},
+		{&servingRange{Min: servings(0), Max: servings(0)}, 0, true},
+	}
+	for _, test := range tests {
+		if got := test.r.contains(test.servings); got != test.want {
+			t.Errorf("%+v.contains(%v) = %v, want %v", test.r, test.servings, got, test.want)
+		}
+	}
+}
+
+func TestMatchDish(t *testing.T) {
+	dish := &Dish{DishType: "Entree", Rating: 4, PrepTimeMinutes: 10,
+		CookTimeMinutes: 15, ServingsProtein: 1, ServingsVeggies: 2}
+	unrated := &Dish{DishType: "Side"}
+	tests := []struct {
+		sp   searchParams
+		dish *Dish
+		want bool
+	}{
+		{searchParams{}, dish, true},
+		{searchParams{DishType: "Entree"}, dish, true},
+		{searchParams{DishType: "Side"}, dish, false},
+		{searchParams{MinRating: 4}, dish, true},
+		{searchParams{MinRating: 5}, dish, false},
+		{searchParams{Unrated: true}, dish, false},
+		{searchParams{Unrated: true}, unrated, true},
+		{searchParams{MaxTotalMinutes: 25}, dish, true},
+		{searchParams{MaxTotalMinutes: 20}, dish, false},
+		{searchParams{ServingsVeggies: &servingRange{Min: servings(2)}}, dish, true},
+		{searchParams{ServingsProtein: &servingRange{Max: servings(0.5)}}, dish, false},
+		{searchParams{ServingsCarb: &servingRange{Min: servings(1)}}, dish, false},
+		// quick entree rated 4+
+		{searchParams{DishType: "Entree", MinRating: 4, MaxTotalMinutes: 30}, dish, true},
+	}
+	for _, test := range tests {
+		if got := test.sp.matchDish(test.dish); got != test.want {
+			t.Errorf("%+v matchDish(%+v) = %v, want %v", test.sp, test.dish, got, test.want)
+		}
+	}
+}
+
+func TestMatchIngredient(t *testing.T) {
+	tofu := &Ingredient{Category: "Protein", Source: "Vegan"}
+	cheese := &Ingredient{Category: "Dairy", Source: "Vegetarian"}
+	tests := []struct {
+		sp   searchParams
+		ing  *Ingredient
+		want bool
+	}{
+		{searchParams{}, tofu, true},
+		{searchParams{IngredientCategory: "Protein"}, tofu, true},
+		{searchParams{IngredientCategory: "Dairy"}, tofu, false},
+		{searchParams{IngredientSource: "Vegan"}, tofu, true},
+		{searchParams{IngredientSource: "Vegan"}, cheese, false},
+		// vegan is vegetarian too
+		{searchParams{IngredientSource: "Vegetarian"}, tofu, true},
+		{searchParams{IngredientSource: "Vegetarian"}, cheese, true},
+		{searchParams{IngredientSource: "Animal"}, cheese, false},
+		{searchParams{IngredientCategory: "Dairy", IngredientSource: "Vegan"}, cheese, false},
+	}
+	for _, test := range tests {
+		if got := test.sp.matchIngredient(test.ing); got != test.want {
+			t.Errorf("%+v matchIngredient(%+v) = %v, want %v", test.sp, test.ing, got, test.want)
+		}
+	}
+}
+
+func TestMatchDishIngredients(t *testing.T) {
+	tofu := &Ingredient{Category: "Protein", Source: "Vegan"}
+	rice := &Ingredient{Category: "Grain", Source: "Vegan"}
+	egg := &Ingredient{Category: "Protein", Source: "Vegetarian"}
+	pork := &Ingredient{Category: "Protein", Source: "Animal"}
+	unknown := &Ingredient{Category: "Spice"}
+	tests := []struct {
+		sp   searchParams
+		ings []*Ingredient
+		want bool
+	}{
+		{searchParams{}, nil, true},
+		// some ingredient must be in the category
+		{searchParams{IngredientCategory: "Grain"}, []*Ingredient{tofu, rice}, true},
+		{searchParams{IngredientCategory: "Grain"}, []*Ingredient{tofu, egg}, false},
+		{searchParams{IngredientCategory: "Grain"}, nil, false},
+		// every ingredient must fit the source
+		{searchParams{IngredientSource: "Vegan"}, []*Ingredient{tofu, rice}, true},
+		{searchParams{IngredientSource: "Vegan"}, []*Ingredient{rice, egg}, false},
+		{searchParams{IngredientSource: "Vegetarian"}, []*Ingredient{rice, egg}, true},
+		{searchParams{IngredientSource: "Vegetarian"}, []*Ingredient{tofu, rice}, true},
+		{searchParams{IngredientSource: "Vegetarian"}, []*Ingredient{egg, pork}, false},
+		{searchParams{IngredientSource: "Animal"}, []*Ingredient{egg, pork}, true},
+		{searchParams{IngredientSource: "Vegetarian"}, []*Ingredient{rice, unknown}, false},
+		// dishes without ingredients have no source
+		{searchParams{IngredientSource: "Vegan"}, nil, false},
+	}
+	for _, test := range tests {
+		if got := test.sp.matchDishIngredients(test.ings); got != test.want {
+			t.Errorf("%+v matchDishIngredients(%d ingredients) = %v, want %v",
+				test.sp, len(test.ings), got, test.want)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package mealplanner
+
+// correction of misspelled search words using the words of the libraries
+
+import (
+	"appengine"
+	"appengine/datastore"
+	"appengine/memcache"
+	"sort"
+	"strings"
+)
+
+// the most "did you mean" queries suggested for a search
+const maxCorrections = 3
+
+// get the memcache key for the vocabulary of the library
+//  holding the key, which may be the library itself or any item in it
+func vocabularyCacheKey(key *datastore.Key) string {
+	for key.Parent() != nil {
+		key = key.Parent()
+	}
+	return key.Encode() + "/vocabulary"
+}
+
+// the words of a library, used to correct misspelled search words
+type vocabulary struct {
+	// the number of items with each keyword
+	Keywords map[string]int
+	// the number of items with each word that gives a keyword, as
+	//  tokenize gives it, these are suggested rather than the keywords,
+	//  which may be stems, e.g. "cookies" rather than "cooki"
+	Words map[string]int
+}
+
+// get the vocabulary of the library
+//  checks memcache first, then datastore.  Populates the cache
+//  the words are only rebuilt when keywords change, so a word may
+//  linger after an edit that leaves the keywords the same
+func getVocabulary(c *context) *vocabulary {
+	v := &vocabulary{make(map[string]int), make(map[string]int)}
+	cacheKey := vocabularyCacheKey(c.lid)
+	if _, err := memcache.Gob.Get(c.c, cacheKey, v); err == nil {
+		return v
+	}
+	v = &vocabulary{make(map[string]int), make(map[string]int)}
+	a := c.analyzer()
+	for _, kind := range searchKinds {
+		iter := c.NewQuery(kind).Run(c.c)
+		item := newTaggedItem(kind)
+		for _, err := iter.Next(item); err != datastore.Done; _, err = iter.Next(item) {
+			check(err)
+			for _, keyword := range item.keywords() {
+				v.Keywords[keyword]++
+			}
+			// count each word once per item
+			words := make(map[string]bool)
+			for _, text := range indexedText(item) {
+				for _, word := range a.tokenize(text) {
+					if len(a.keyword(word)) > 0 {
+						words[word] = true
+					}
+				}
+			}
+			for word, _ := range words {
+				v.Words[word]++
+			}
+			item = newTaggedItem(kind)
+		}
+	}
+	memcache.Gob.Set(c.c, &memcache.Item{Key: cacheKey, Object: v})
+	return v
+}
+
+// get the contexts of the libraries searched, the current library or
+//  every library the user can access if AllLibraries is set
+func searchedLibraries(c *context, sp *searchParams) []*context {
+	if !sp.AllLibraries {
+		return []*context{c}
+	}
+	libraries, libs := userLibraries(c)
+	contexts := make([]*context, len(libraries))
+	for i, library := range libraries {
+		libContext := *c
+		libContext.lid = library.Id
+		libContext.l = libs[i]
+		libContext.readOnly = library.ReadOnly
+		contexts[i] = &libContext
+	}
+	return contexts
+}
+
+// remove the cached vocabulary of the library holding the key,
+//  called when keywords are added or removed
+func clearVocabulary(c appengine.Context, key *datastore.Key) {
+	memcache.Delete(c, vocabularyCacheKey(key))
+}
+
+// a word close to a misspelled word
+type closeWord struct {
+	word string
+	// the edit distance from the misspelled word
+	distance int
+	// how many items have the word
+	count int
+}
+
+// sort close words with the nearest, then most common, first
+type closeWordsByDistance []closeWord
+
+func (self closeWordsByDistance) Len() int {
+	return len(self)
+}
+func (self closeWordsByDistance) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+func (self closeWordsByDistance) Less(i, j int) bool {
+	if self[i].distance != self[j].distance {
+		return self[i].distance < self[j].distance
+	}
+	if self[i].count != self[j].count {
+		return self[i].count > self[j].count
+	}
+	return self[i].word < self[j].word
+}
+
+// the largest edit distance allowed for a word
+//  short words need to be closer, or everything would match
+func maxEditDistance(word []rune) int {
+	switch {
+	case len(word) <= 2:
+		return 0
+	case len(word) <= 5:
+		return 1
+	}
+	return 2
+}
+
+// find the words of the vocabulary close to the word, nearest first
+func findCloseWords(word string, vocabulary map[string]int, limit int) []string {
+	target := []rune(word)
+	maxDistance := maxEditDistance(target)
+	found := make([]closeWord, 0, 10)
+	for other, count := range vocabulary {
+		candidate := []rune(other)
+		lengthDifference := len(candidate) - len(target)
+		if lengthDifference > maxDistance || -lengthDifference > maxDistance {
+			continue
+		}
+		distance := editDistance(target, candidate)
+		if distance > 0 && distance <= maxDistance {
+			found = append(found, closeWord{other, distance, count})
+		}
+	}
+	sort.Sort(closeWordsByDistance(found))
+	if len(found) > limit {
+		found = found[:limit]
+	}
+	words := make([]string, len(found))
+	for i, _ := range found {
+		words[i] = found[i].word
+	}
+	return words
+}
+
+// count the insertions, deletions, substitutions and swaps of
+//  neighboring letters needed to turn a into b
+func editDistance(a, b []rune) int {
+	// rows of the table for the two previous letters of a
+	previous2 := make([]int, len(b)+1)
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j, _ := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				current[j] = minInt(current[j], previous2[j-2]+1)
+			}
+		}
+		previous2, previous, current = previous, current, previous2
+	}
+	return previous[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// build "did you mean" queries for the search, replacing words that
+//  aren't keywords of the libraries searched with close words
+//  returns nil if no words could be corrected
+func correctQuery(c *context, sp *searchParams) []string {
+	query := sp.query()
+	if query == nil {
+		return nil
+	}
+	a := c.analyzer()
+	// gather the words of the libraries searched
+	libraries := searchedLibraries(c, sp)
+	vocabularies := make([]*vocabulary, len(libraries))
+	words := make(map[string]int)
+	for i, lc := range libraries {
+		vocabularies[i] = getVocabulary(lc)
+		for word, count := range vocabularies[i].Words {
+			words[word] += count
+		}
+	}
+	// check if any library searched has the keyword of the word
+	known := func(word string) bool {
+		for i, lc := range libraries {
+			keyword := lc.analyzer().keyword(word)
+			if len(keyword) > 0 && vocabularies[i].Keywords[keyword] > 0 {
+				return true
+			}
+		}
+		return false
+	}
+	// find the close words for each word that isn't found
+	corrections := make(map[*queryNode][]string)
+	for _, term := range query.positiveTerms(nil) {
+		if term.kind != queryWord {
+			continue
+		}
+		tokens := a.tokenize(term.text)
+		if len(tokens) != 1 {
+			continue
+		}
+		word := tokens[0]
+		if len(a.keyword(word)) == 0 || known(word) {
+			continue
+		}
+		if close := findCloseWords(word, words, maxCorrections); len(close) > 0 {
+			corrections[term] = close
+		}
+	}
+	if len(corrections) == 0 {
+		return nil
+	}
+	// the first query uses the closest word for every word,
+	//  the others use the next closest
+	queries := make([]string, 0, maxCorrections)
+	for i := 0; i < maxCorrections; i++ {
+		replacements := make(map[*queryNode]string)
+		for term, close := range corrections {
+			replacements[term] = close[minInt(i, len(close)-1)]
+		}
+		corrected := query.format(replacements)
+		if len(queries) == 0 || queries[len(queries)-1] != corrected {
+			queries = append(queries, corrected)
+		}
+	}
+	return queries
+}
+
+// write the query in the language parsed by parseQuery, using the
+//  replacement text for any nodes in the map
+func (self *queryNode) format(replacements map[*queryNode]string) string {
+	text := self.text
+	if replacement, found := replacements[self]; found {
+		text = replacement
+	}
+	switch self.kind {
+	case queryAnd, queryOr:
+		parts := make([]string, len(self.children))
+		for i, child := range self.children {
+			parts[i] = child.format(replacements)
+			// OR binds looser than AND
+			if self.kind == queryAnd && child.kind == queryOr {
+				parts[i] = "(" + parts[i] + ")"
+			}
+		}
+		if self.kind == queryOr {
+			return strings.Join(parts, " OR ")
+		}
+		return strings.Join(parts, " ")
+	case queryNot:
+		child := self.children[0].format(replacements)
+		if self.children[0].kind == queryAnd || self.children[0].kind == queryOr {
+			child = "(" + child + ")"
+		}
+		return "-" + child
+	case queryPhrase:
+		return "\"" + text + "\""
+	case queryTag:
+		if strings.IndexAny(text, " ()\"") >= 0 {
+			return "tag:\"" + text + "\""
+		}
+		return "tag:" + text
+	}
+	return text
+}
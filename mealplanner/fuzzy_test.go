@@ -0,0 +1,82 @@
+package mealplanner
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"", "rice", 4},
+		{"rice", "", 4},
+		{"rice", "rice", 0},
+		// substitution, insertion, deletion
+		{"rice", "mice", 1},
+		{"rice", "price", 1},
+		{"rice", "ric", 1},
+		// swapped neighbors count once
+		{"cookign", "cooking", 1},
+		{"ab", "ba", 1},
+		{"kitten", "sitting", 3},
+		{"jalapeño", "jalapeno", 1},
+	}
+	for _, test := range tests {
+		if got := editDistance([]rune(test.a), []rune(test.b)); got != test.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestMaxEditDistance(t *testing.T) {
+	tests := []struct {
+		word string
+		want int
+	}{
+		{"ox", 0},
+		{"egg", 1},
+		{"basil", 1},
+		{"tomato", 2},
+	}
+	for _, test := range tests {
+		if got := maxEditDistance([]rune(test.word)); got != test.want {
+			t.Errorf("maxEditDistance(%q) = %d, want %d", test.word, got, test.want)
+		}
+	}
+}
+
+func TestFindCloseWords(t *testing.T) {
+	vocabulary := map[string]int{
+		"cooking": 3,
+		"cookies": 5,
+		"cookie":  1,
+		"tomato":  4,
+		"potato":  2,
+		"egg":     1,
+	}
+	tests := []struct {
+		word  string
+		limit int
+		want  []string
+	}{
+		// the word itself isn't a correction
+		{"tomato", 3, []string{"potato"}},
+		{"cookign", 3, []string{"cooking", "cookies", "cookie"}},
+		// short words allow fewer edits
+		{"cooki", 3, []string{"cookie"}},
+		// nearest first, then the most common
+		{"cookis", 3, []string{"cookies", "cookie", "cooking"}},
+		{"cookis", 1, []string{"cookies"}},
+		{"eg", 3, []string{}},
+		{"ham", 3, []string{}},
+	}
+	for _, test := range tests {
+		got := findCloseWords(test.word, vocabulary, test.limit)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("findCloseWords(%q) = %v, want %v", test.word, got, test.want)
+		}
+	}
+}
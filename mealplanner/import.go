@@ -16,9 +16,13 @@ type backup struct {
 	Ingredients []Ingredient
 	// mapping from the data store key of items to their children
 	MeasuredIngredients map[string][]MeasuredIngredient
-	Tags                map[string][]Word
-	Pairings            map[string][]Pairing
-	Menus               []Menu
+	// tags of the items in backups from before tags were kept in
+	//  the Tags of each item
+	Tags          map[string][]Word
+	Pairings      map[string][]Pairing
+	PairingTypes  []PairingType
+	Menus         []Menu
+	SavedSearches []SavedSearch
 }
 
 // class to handle the import
@@ -32,20 +36,20 @@ type importer struct {
 	//  only for the case that the string-id isn't a valid key for our
 	//  library
 	fixUpKeys map[string]*datastore.Key
-	// an index of tags, (dish|ingredient)key -> tagstr -> dummy
+	// an index of the tags already stored, (dish|ingredient|menu)key -> tags
 	//  keyed based on the actual datastore key we will use, not
 	//  the string from json
-	allTags map[string]map[string]bool
-	// slice of all new tags to be added
-	newTags []interface{}
-	// slice of the keys for the new tags to be added
-	newTagKeys []*datastore.Key
+	allTags map[string][]string
 	// slice of memcache entries that need to be purged
 	dirtyCacheEntries []string
+	// keys of the imported items, their keywords are updated by
+	//  background tasks once the import is done
+	indexKeys []*datastore.Key
 }
 
 // method to import from JSON read with the file reader
 func importFile(c *context, file io.Reader) {
+	var indexKeys []*datastore.Key
 	// run in a transaction so that each datastore write doesn't redo the index,
 	//  rather all the indecies get updated at once when we're done
 	datastore.RunInTransaction(c.c, func(tc appengine.Context) error {
@@ -59,16 +63,19 @@ func importFile(c *context, file io.Reader) {
 			context:           *c,
 			jsonData:          data,
 			fixUpKeys:         make(map[string]*datastore.Key),
-			allTags:           make(map[string]map[string]bool),
-			newTags:           make([]interface{}, 0, 100),
-			newTagKeys:        make([]*datastore.Key, 0, 100),
+			allTags:           make(map[string][]string),
 			dirtyCacheEntries: make([]string, 0, 1000),
+			indexKeys:         make([]*datastore.Key, 0, 1000),
 		}
 		worker.c = tc
 		// kick off the import
 		worker.doImport()
+		indexKeys = worker.indexKeys
 		return nil
 	}, nil)
+	// tasks can't be added in bulk within the transaction, so the
+	//  keywords are only queued once the items are stored
+	queueIndex(c, indexKeys...)
 }
 
 // perform an import using the data we've decoded in jsonData
@@ -88,11 +95,10 @@ func (self *importer) doImport() {
 	self.importIngredients()
 	self.importDishes()
 	self.importMeasuredIngredients()
+	self.importPairingTypes()
 	self.importPairings()
 	self.importMenus()
-	// add the tags we collected
-	_, err := datastore.PutMulti(self.c, self.newTagKeys, self.newTags)
-	check(err)
+	self.importSavedSearches()
 	// clear the cache
 	lid := self.lid.Encode()
 	// prefix each entry with the library id
@@ -103,25 +109,18 @@ func (self *importer) doImport() {
 	memcache.DeleteMulti(self.c, self.dirtyCacheEntries)
 }
 
-// build an index of tags, (dish|ingredient)key -> tagstr -> tagkey
+// build an index of tags, (dish|ingredient|menu)key -> tags
 func (self *importer) indexCurrentTags() {
-	// query for all of the tags in this library
-	query := self.NewQuery("Tags")
-	// use the iterator to walk through results
-	iter := query.Run(self.c)
-	word := &Word{}
-	// loop through the results from the iterator
-	for key, err := iter.Next(word); err == nil; key, err = iter.Next(word) {
-		parent := key.Parent().Encode()
-		// add map for each parent we find
-		var m map[string]bool
-		var found bool
-		if m, found = self.allTags[parent]; !found {
-			m = make(map[string]bool)
-			self.allTags[parent] = m
+	for _, kind := range searchKinds {
+		// use the iterator to walk through the items of the kind
+		iter := self.NewQuery(kind).Run(self.c)
+		item := newTaggedItem(kind)
+		for key, err := iter.Next(item); err == nil; key, err = iter.Next(item) {
+			if len(item.tags()) > 0 {
+				self.allTags[key.Encode()] = item.tags()
+			}
+			item = newTaggedItem(kind)
 		}
-		// add the word to the map for the parent
-		m[word.Word] = true
 	}
 }
 
@@ -181,6 +180,7 @@ func (self *importer) importIngredients() {
 			}
 		}
 		i.Id = ""
+		self.mergeTags(id, key, i)
 		putItems = append(putItems, i)
 		putKeys = append(putKeys, key)
 		putIds = append(putIds, id)
@@ -200,19 +200,7 @@ func (self *importer) importIngredients() {
 		}
 	}
 
-	// add tags
-	self.importTags(putIds, outKeys)
-	// update keywords
-	for index, _ := range putItems {
-		ing := putItems[index].(*Ingredient)
-		words := make(map[string]bool)
-		addWords(ing.Name, words)
-		addWords(ing.Category, words)
-		for tag, _ := range self.allTags[outKeys[index].Encode()] {
-			addWords(tag, words)
-		}
-		updateKeywords(self.c, outKeys[index], words)
-	}
+	self.indexKeys = append(self.indexKeys, outKeys...)
 }
 
 // import all of the dishes from jsonData
@@ -241,6 +229,7 @@ func (self *importer) importDishes() {
 				key = ikey
 			}
 		}
+		self.mergeTags(id, key, i)
 		putItems = append(putItems, i)
 		putKeys = append(putKeys, key)
 		putIds = append(putIds, id)
@@ -261,19 +250,7 @@ func (self *importer) importDishes() {
 		}
 	}
 
-	// add tags
-	self.importTags(putIds, outKeys)
-	// update keywords
-	for index, _ := range putItems {
-		dish := putItems[index].(*Dish)
-		words := make(map[string]bool)
-		addWords(dish.Name, words)
-		addWords(dish.Source, words)
-		for tag, _ := range self.allTags[outKeys[index].Encode()] {
-			addWords(tag, words)
-		}
-		updateKeywords(self.c, outKeys[index], words)
-	}
+	self.indexKeys = append(self.indexKeys, outKeys...)
 }
 
 // import all measured ingredients fro jsonData
@@ -329,6 +306,58 @@ func (self *importer) importMeasuredIngredients() {
 	}
 }
 
+// import the pairing types that aren't in the library yet
+func (self *importer) importPairingTypes() {
+	// index existing items by their name
+	prevTypes := self.indexItems(self.NewQuery("PairingType"), &PairingType{},
+		func(key *datastore.Key, item interface{}) string {
+			return item.(*PairingType).Name
+		})
+	count := len(self.jsonData.PairingTypes)
+	putItems := make([]interface{}, 0, count)
+	putKeys := make([]*datastore.Key, 0, count)
+	for index, _ := range self.jsonData.PairingTypes {
+		jsonType := &self.jsonData.PairingTypes[index]
+		if _, found := prevTypes[jsonType.Name]; found {
+			continue
+		}
+		jsonType.Id = ""
+		putItems = append(putItems, jsonType)
+		putKeys = append(putKeys, datastore.NewIncompleteKey(self.c, "PairingType", self.lid))
+	}
+	if len(putKeys) > 0 {
+		_, err := datastore.PutMulti(self.c, putKeys, putItems)
+		check(err)
+		self.dirtyCacheEntries = append(self.dirtyCacheEntries, "/pairingtype/")
+	}
+}
+
+// import the saved searches that aren't already in the library
+func (self *importer) importSavedSearches() {
+	// index existing items by their name
+	prevSearches := self.indexItems(self.NewQuery("SavedSearch"), &SavedSearch{},
+		func(key *datastore.Key, item interface{}) string {
+			return item.(*SavedSearch).Name
+		})
+	count := len(self.jsonData.SavedSearches)
+	putItems := make([]interface{}, 0, count)
+	putKeys := make([]*datastore.Key, 0, count)
+	for index, _ := range self.jsonData.SavedSearches {
+		jsonSearch := &self.jsonData.SavedSearches[index]
+		if _, found := prevSearches[jsonSearch.Name]; found {
+			continue
+		}
+		jsonSearch.Id = ""
+		putItems = append(putItems, jsonSearch)
+		putKeys = append(putKeys, datastore.NewIncompleteKey(self.c, "SavedSearch", self.lid))
+	}
+	if len(putKeys) > 0 {
+		_, err := datastore.PutMulti(self.c, putKeys, putItems)
+		check(err)
+		self.dirtyCacheEntries = append(self.dirtyCacheEntries, "/saved/")
+	}
+}
+
 // import all dish pairings
 //jsonData.Pairings map[string][]Pairing
 func (self *importer) importPairings() {
@@ -396,70 +425,74 @@ func (self *importer) importMenus() {
 	// walk each menu
 	for index, _ := range self.jsonData.Menus {
 		jsonMenu := &self.jsonData.Menus[index]
+		id := jsonMenu.Id
 		// get the key to store to
-		key := self.restoreKey(jsonMenu.Id, self.lid)
+		key := self.restoreKey(id, self.lid)
 		if key.Incomplete() {
 			// check if we already have a menu by this name
 			if existingKey, found := prevMenus[jsonMenu.Name]; found {
 				key = existingKey
 			}
 		}
-		// walk the dishes, keeping only the ones we can reference properly
-		newDishes := make([]*datastore.Key, 0, len(jsonMenu.Dishes))
-		for _, dishKey := range jsonMenu.Dishes {
-			destKey := self.restoreKey(dishKey.Encode(), self.lid)
+		// backups from older versions only list the dishes
+		jsonMenu.migrateDishes()
+		// walk the items, keeping only the ones we can reference properly
+		newItems := make([]MenuItem, 0, len(jsonMenu.Items))
+		for _, item := range jsonMenu.Items {
+			if item.Dish == nil {
+				continue
+			}
+			destKey := self.restoreKey(item.Dish.Encode(), self.lid)
 			if !destKey.Incomplete() {
-				newDishes = append(newDishes, destKey)
+				item.Dish = destKey
+				newItems = append(newItems, item)
 			}
 		}
 		// add this menu to the list to be added
-		jsonMenu.Dishes = newDishes
+		jsonMenu.Items = newItems
 		jsonMenu.Id = ""
+		self.mergeTags(id, key, jsonMenu)
 		putItems = append(putItems, jsonMenu)
 		putKeys = append(putKeys, key)
 	}
+	if len(putKeys) == 0 {
+		return
+	}
 	// store the menus and clear the cache
-	if len(putKeys) > 0 {
-		_, err := datastore.PutMulti(self.c, putKeys, putItems)
-		check(err)
-		// any modified entries need to be cleared from the cache
-		for _, putKey := range putKeys {
-			if !putKey.Incomplete() {
-				self.dirtyCacheEntries = append(self.dirtyCacheEntries, "/menu/"+"/menu/"+putKey.Encode())
-			}
+	outKeys, err := datastore.PutMulti(self.c, putKeys, putItems)
+	check(err)
+	// any modified entries need to be cleared from the cache
+	for _, putKey := range putKeys {
+		if !putKey.Incomplete() {
+			self.dirtyCacheEntries = append(self.dirtyCacheEntries, "/menu/"+putKey.Encode())
+			self.dirtyCacheEntries = append(self.dirtyCacheEntries, "/menu/"+putKey.Encode()+"/tags/")
+			self.dirtyCacheEntries = append(self.dirtyCacheEntries, "/menu/"+putKey.Encode()+"/keywords/")
 		}
 	}
+
+	self.indexKeys = append(self.indexKeys, outKeys...)
 }
 
-// jsonData.Tags map[string][]Word
-// take a slice of ids as appeared in json and slice of
-//  keys as now stored for items with tags
-// add any tags for these items that were in json but not yet stored
-func (self *importer) importTags(ids []string, keys []*datastore.Key) {
-	for index, parentKey := range keys {
-		parentId := ids[index]
-		destId := keys[index].Encode()
-		if importTags, ok := self.jsonData.Tags[parentId]; ok {
-			var myTags map[string]bool
-			// if this item doesn't have a tags collection yet,
-			// add it
-			var found bool
-			if myTags, found = self.allTags[destId]; !found {
-				myTags = make(map[string]bool)
-				self.allTags[destId] = myTags
-			}
-			// go through tags from json
-			for _, tag := range importTags {
-				if _, found := myTags[tag.Word]; !found {
-					// this tag doesn't exist yet, add to our list
-					self.newTags = append(self.newTags, &Word{"", tag.Word})
-					newTagKey := datastore.NewIncompleteKey(self.c, "Tags", parentKey)
-					self.newTagKeys = append(self.newTagKeys, newTagKey)
-					myTags[tag.Word] = true
-				}
-			}
+// merge the tags of an item being imported with those it already has
+//  and any listed for it in jsonData.Tags by older backups
+// id is the id of the item in json, key is where it will be stored
+func (self *importer) mergeTags(id string, key *datastore.Key, item taggedItem) {
+	tags := make([]string, 0, 10)
+	if !key.Incomplete() {
+		tags = append(tags, self.allTags[key.Encode()]...)
+	}
+	for _, tag := range item.tags() {
+		if !hasTag(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	for _, word := range self.jsonData.Tags[id] {
+		if !hasTag(tags, word.Word) {
+			tags = append(tags, word.Word)
 		}
 	}
+	item.setTags(tags)
+	item.setIndexStatus(indexPending)
 }
 
 // create an index for items, by calling the keyFunc function provided for each item
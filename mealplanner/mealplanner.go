@@ -8,15 +8,18 @@ import (
 	"appengine/mail"
 	"appengine/memcache"
 	"appengine/user"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"reflect"
+	"sort"
 	"strings"
 	"time"
-	"unicode"
 )
 
 // Error constants
@@ -24,6 +27,8 @@ var (
 	ErrUnknownItem      = errors.New("Unknown item")
 	ErrUnsupported      = errors.New("Unsupported action")
 	ErrPermissionDenied = errors.New("Permission Denied")
+	ErrInvalidPairing   = errors.New("Invalid pairing")
+	ErrBadQuery         = errors.New("Invalid search query")
 )
 
 // setup the handler functions
@@ -35,7 +40,13 @@ func init() {
 	http.HandleFunc("/ingredient", cacheHandler(ingredientHandler))
 	http.HandleFunc("/ingredient/", cacheHandler(ingredientHandler))
 	http.HandleFunc("/menu/", cacheHandler(menuHandler))
+	http.HandleFunc("/pairingtype/", cacheHandler(pairingTypeHandler))
+	http.HandleFunc("/saved/", cacheHandler(savedSearchHandler))
 	http.HandleFunc("/tags", permHandler(allTagsHandler))
+	http.HandleFunc("/tags/", permHandler(libraryTagsHandler))
+	http.HandleFunc("/suggest", permHandler(completionHandler))
+	http.HandleFunc("/targets", permHandler(targetsHandler))
+	http.HandleFunc("/stats", permHandler(statsHandler))
 	http.HandleFunc("/backup", permHandler(backupHandler))
 	http.HandleFunc("/restore", permHandler(restoreHandler))
 	http.HandleFunc("/share/", errorHandler(shareHandler))
@@ -43,9 +54,23 @@ func init() {
 	http.HandleFunc("/libraries", errorHandler(librariesHandler))
 	http.HandleFunc("/switch/", errorHandler(switchHandler))
 	http.HandleFunc("/deletelib", errorHandler(deletelibHandler))
+	http.HandleFunc("/reindex", permHandler(reindexHandler))
 	// search uses POST for a read, we don't use permHandler because
 	// it would block searches of readonly libraries
 	http.HandleFunc("/search", errorHandler(searchHandler))
+	http.HandleFunc("/facets", errorHandler(facetsHandler))
+	// picking a dish is a read too, more specific than /dish/ so the
+	//  dish handler doesn't see it
+	http.HandleFunc("/dish/random", errorHandler(randomDishHandler))
+	// background tasks, see tasks.go
+	taskHandlers = map[string]handlerFunc{
+		"/task/index":   indexTaskHandler,
+		"/task/migrate": migrateTaskHandler,
+		"/task/retag":   retagTaskHandler,
+	}
+	for path, handler := range taskHandlers {
+		http.HandleFunc(path, taskHandler(handler))
+	}
 }
 
 // context structure to carry common data we need for most of our handlers
@@ -117,6 +142,56 @@ func check(err error) {
 	}
 }
 
+// the most entities written or deleted by one datastore call
+const datastoreBatchSize = 500
+
+// store the items in batches of datastoreBatchSize
+func putMulti(c appengine.Context, keys []*datastore.Key, items []interface{}) []*datastore.Key {
+	outKeys := make([]*datastore.Key, 0, len(keys))
+	for start := 0; start < len(keys); start += datastoreBatchSize {
+		end := start + datastoreBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		batch, err := datastore.PutMulti(c, keys[start:end], items[start:end])
+		check(err)
+		outKeys = append(outKeys, batch...)
+	}
+	return outKeys
+}
+
+// fetch the items with the keys into the slice items, in batches of
+//  datastoreBatchSize, returns the error for each key, e.g.
+//  datastore.ErrNoSuchEntity for items that no longer exist
+func getMulti(c appengine.Context, keys []*datastore.Key, items interface{}) []error {
+	errs := make([]error, len(keys))
+	value := reflect.ValueOf(items)
+	for start := 0; start < len(keys); start += datastoreBatchSize {
+		end := start + datastoreBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		err := datastore.GetMulti(c, keys[start:end], value.Slice(start, end).Interface())
+		if multi, ok := err.(appengine.MultiError); ok {
+			copy(errs[start:end], multi)
+		} else {
+			check(err)
+		}
+	}
+	return errs
+}
+
+// delete the items with the keys in batches of datastoreBatchSize
+func deleteMulti(c appengine.Context, keys []*datastore.Key) {
+	for start := 0; start < len(keys); start += datastoreBatchSize {
+		end := start + datastoreBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		check(datastore.DeleteMulti(c, keys[start:end]))
+	}
+}
+
 // redirect "/" to "index.html"
 func indexHandler(c *context) {
 	http.Redirect(c.w, c.r, "/index.html", http.StatusFound)
@@ -138,16 +213,12 @@ func dishHandler(c *context) {
 	}
 	// handle tags
 	if strings.Contains(c.r.URL.Path, "/tags/") {
-		wordHandler(c, "Tags")
-		// update keywords if tags have changed
-		if c.r.Method != "GET" {
-			updateDishKeywordsKeyStr(c, getParentID(c.r))
-		}
+		tagsHandler(c)
 		return
 	}
 	// for debugging, get keywords
 	if strings.Contains(c.r.URL.Path, "/keywords/") {
-		wordHandler(c, "Keyword")
+		keywordsHandler(c)
 		return
 	}
 	// handle pairings
@@ -155,6 +226,21 @@ func dishHandler(c *context) {
 		pairingHandler(c)
 		return
 	}
+	// handle other dishes like this one
+	if strings.HasSuffix(c.r.URL.Path, "/similar") {
+		similarDishesHandler(c)
+		return
+	}
+	// handle suggestions of dishes from the same menus
+	if strings.Contains(c.r.URL.Path, "/menupairs/") {
+		menuPairingsHandler(c)
+		return
+	}
+	// record that the dish was cooked
+	if strings.HasSuffix(c.r.URL.Path, "/cooked") {
+		cookedHandler(c)
+		return
+	}
 	// use the standard data handler, add post-processing via callback
 	//  so we can update keywords and remove references to this dish
 	//  when items are written and deleted
@@ -164,16 +250,26 @@ func dishHandler(c *context) {
 			switch method {
 			case "POST", "PUT":
 				// update keyword index after a change
-				updateDishKeywords(c, key, item.(*Dish))
+				keys := []*datastore.Key{key}
+				// menus are indexed by the names of their dishes
+				if method == "PUT" {
+					query := c.NewQuery("Menu").Filter("Items.Dish =", key).KeysOnly()
+					menuKeys, err := query.GetAll(c.c, nil)
+					check(err)
+					keys = append(keys, menuKeys...)
+				}
+				queueIndex(c, keys...)
 			case "DELETE":
-				// remove any measured ingredients of this dish
-				query := c.NewQuery("MeasuredIngredient").Ancestor(key).KeysOnly()
-				keys, err := query.GetAll(c.c, nil)
-				check(err)
-				datastore.DeleteMulti(c.c, keys)
+				// remove any measured ingredients and name prefixes of this dish
+				for _, kind := range []string{"MeasuredIngredient", "Prefix"} {
+					query := datastore.NewQuery(kind).Ancestor(key).KeysOnly()
+					keys, err := query.GetAll(c.c, nil)
+					check(err)
+					datastore.DeleteMulti(c.c, keys)
+				}
 				// removing any pairings that reference this dish
-				query = c.NewQuery("Pairing").Filter("Other=", key).KeysOnly()
-				keys, err = query.GetAll(c.c, nil)
+				query := c.NewQuery("Pairing").Filter("Other=", key).KeysOnly()
+				keys, err := query.GetAll(c.c, nil)
 				check(err)
 				datastore.DeleteMulti(c.c, keys)
 				for _, pk := range keys {
@@ -185,16 +281,18 @@ func dishHandler(c *context) {
 				menu := &Menu{}
 				for mkey, err := iter.Next(menu); err != datastore.Done; mkey, err = iter.Next(menu) {
 					check(err)
-					newDishes := make([]*datastore.Key, 0, len(menu.Dishes))
-					for _, dkey := range menu.Dishes {
-						if !key.Equal(dkey) {
-							newDishes = append(newDishes, dkey)
+					newItems := make([]MenuItem, 0, len(menu.Items))
+					for _, item := range menu.Items {
+						if !key.Equal(item.Dish) {
+							newItems = append(newItems, item)
 						}
 					}
-					if len(newDishes) < len(menu.Dishes) {
-						menu.Dishes = newDishes
+					if len(newItems) < len(menu.Items) {
+						menu.Items = newItems
+						menu.IndexStatus = indexPending
 						_, err = datastore.Put(c.c, mkey, menu)
 						check(err)
+						queueIndex(c, mkey)
 						// flush the cache for menus 
 						memcache.Delete(c.c, c.lid.Encode()+"/menu/"+mkey.Encode())
 						memcache.Delete(c.c, c.lid.Encode()+"/menu/")
@@ -206,108 +304,157 @@ func dishHandler(c *context) {
 		})
 }
 
-// query data store for tags applied to the element with key given,
-//  add them to the map passed in
-func addTags(c appengine.Context, key *datastore.Key, words map[string]bool) {
-	query := datastore.NewQuery("Tags").Ancestor(key)
-	iter := query.Run(c)
-	tag := &Word{}
-	for _, err := iter.Next(tag); err == nil; _, err = iter.Next(tag) {
-		addWords(tag.Word, words)
-	}
+// get the text of the fields of the item that are broken up into its
+//  keywords, the names of the dishes of menus are added by menuKeywords
+func indexedText(item taggedItem) []string {
+	var text []string
+	switch item := item.(type) {
+	case *Dish:
+		text = []string{item.Name, item.Source, item.Text}
+	case *Ingredient:
+		text = []string{item.Name, item.Category}
+	case *Menu:
+		text = []string{item.Name}
+	}
+	return append(text, item.tags()...)
 }
 
-// break up the text into words and add/remove keywords  for the dish
-func updateDishKeywords(c *context, key *datastore.Key, dish *Dish) {
+// break up the text and tags of the dish into the keywords it should have
+func dishKeywords(c *context, key *datastore.Key, dish *Dish) map[string]bool {
 	words := make(map[string]bool)
-	addWords(dish.Name, words)
-	addWords(dish.Source, words)
-	addWords(dish.Text, words)
-	addTags(c.c, key, words)
-	if updateKeywords(c.c, key, words) {
-		// if we made changes, we need to clear the cache
-		cacheKey := c.lid.Encode() + "/dish/" + key.Encode() + "/keywords/"
-		memcache.Delete(c.c, cacheKey)
+	a := c.analyzer()
+	for _, text := range indexedText(dish) {
+		addWords(a, text, words)
 	}
+	return words
 }
 
-// update the keywords for the dish, starting with the encoded key for the dish
-func updateDishKeywordsKeyStr(c *context, keyStr string) {
-	key, err := datastore.DecodeKey(keyStr)
-	check(err)
-	dish := Dish{}
-	err = datastore.Get(c.c, key, &dish)
-	check(err)
-	updateDishKeywords(c, key, &dish)
+// break up the text into words and update the keywords of the dish
+func updateDishKeywords(c *context, key *datastore.Key, dish *Dish) {
+	updateKeywords(c, key, dishKeywords(c, key, dish))
+	// keep the prefixes for completing names up to date too
+	prefixes := make(map[Prefix]bool)
+	addPrefixes("Dish", dish.Name, prefixes)
+	addTagPrefixes(dish.Tags, prefixes)
+	updatePrefixes(c.c, key, prefixes)
+}
+
+// get the keywords the ingredient should have
+func ingredientKeywords(c *context, key *datastore.Key,
+	ing *Ingredient) map[string]bool {
+	words := make(map[string]bool)
+	a := c.analyzer()
+	for _, text := range indexedText(ing) {
+		addWords(a, text, words)
+	}
+	return words
 }
 
 // update the ingredient's keywords
 func updateIngredientKeywords(c *context, key *datastore.Key,
 	ing *Ingredient) {
+	updateKeywords(c, key, ingredientKeywords(c, key, ing))
+	// keep the prefixes for completing names up to date too
+	prefixes := make(map[Prefix]bool)
+	addPrefixes("Ingredient", ing.Name, prefixes)
+	addTagPrefixes(ing.Tags, prefixes)
+	updatePrefixes(c.c, key, prefixes)
+}
+
+// get the keywords the menu should have, from its name, tags and the
+//  names of its dishes
+func menuKeywords(c *context, key *datastore.Key, menu *Menu) map[string]bool {
 	words := make(map[string]bool)
-	addWords(ing.Name, words)
-	addWords(ing.Category, words)
-	addTags(c.c, key, words)
-	if updateKeywords(c.c, key, words) {
-		// if we made changes, we need to clear the cache
-		cacheKey := c.lid.Encode() + "/ingredient/" + key.Encode() + "/keywords/"
-		memcache.Delete(c.c, cacheKey)
-	}
-}
-
-// break apart the text into words, add each word to the given map
-//  also, add singluars by stripping "s" and "es"
-func addWords(text string, words map[string]bool) {
-	spaced := strings.Map(func(rune rune) rune {
-		if unicode.IsPunct(rune) {
-			return ' '
+	a := c.analyzer()
+	for _, text := range indexedText(menu) {
+		addWords(a, text, words)
+	}
+	dishKeys := make([]*datastore.Key, 0, len(menu.Items))
+	for _, item := range menu.Items {
+		if item.Dish != nil {
+			dishKeys = append(dishKeys, item.Dish)
 		}
-		return rune
-	}, text)
-	pieces := strings.Fields(spaced)
-	for _, word := range pieces {
-		if len(word) > 1 {
-			word = strings.ToLower(word)
-			words[word] = false
-			if strings.HasSuffix(word, "s") {
-				words[word[0:len(word)-1]] = false
-				if strings.HasSuffix(word, "es") {
-					words[word[0:len(word)-2]] = false
-				}
-			}
+	}
+	dishes := make([]Dish, len(dishKeys))
+	errs := getMulti(c.c, dishKeys, dishes)
+	for i, _ := range dishes {
+		if errs[i] == datastore.ErrNoSuchEntity {
+			continue
 		}
+		check(errs[i])
+		addWords(a, dishes[i].Name, words)
 	}
+	return words
 }
 
-// deletes or adds keyword entries as children of the key if they
-//  are out of sync with the words map
-// returns true if any entries were added or removed
-func updateKeywords(c appengine.Context, key *datastore.Key, words map[string]bool) bool {
-	query := datastore.NewQuery("Keyword").Ancestor(key)
-	iter := query.Run(c)
+// update the menu's keywords
+func updateMenuKeywords(c *context, key *datastore.Key, menu *Menu) {
+	updateKeywords(c, key, menuKeywords(c, key, menu))
+}
+
+// store the words as the Keywords of the item with the key, and mark
+//  it indexDone
+// the item is read again in a transaction, so changes made since the
+//  words were found aren't lost
+// returns true if the keywords changed
+func updateKeywords(c *context, key *datastore.Key, words map[string]bool) bool {
+	keywords := make([]string, 0, len(words))
+	for word, _ := range words {
+		keywords = append(keywords, word)
+	}
+	sort.Strings(keywords)
 	changed := false
-	word := &Word{}
-	for key, err := iter.Next(word); err == nil; _, err = iter.Next(word) {
-		if _, ok := words[word.Word]; ok {
-			words[word.Word] = true
-		} else {
-			// this keyword isn't here any more
-			datastore.Delete(c, key)
-			changed = true
+	err := datastore.RunInTransaction(c.c, func(tc appengine.Context) error {
+		item := newTaggedItem(key.Kind())
+		if err := datastore.Get(tc, key, item); err != nil {
+			return err
 		}
+		changed = !equalStrings(item.keywords(), keywords)
+		item.setKeywords(keywords)
+		item.setIndexStatus(indexDone)
+		_, err := datastore.Put(tc, key, item)
+		return err
+	}, nil)
+	if err == datastore.ErrNoSuchEntity {
+		// the item was deleted while we were working
+		return false
 	}
-	for word, exists := range words {
-		if !exists {
-			newWord := Word{"", word}
-			newKey := datastore.NewIncompleteKey(c, "Keyword", key)
-			_, err := datastore.Put(c, newKey, &newWord)
-			check(err)
-			changed = true
-		}
+	check(err)
+	// the cached item shows the old status
+	clearItemCache(c, key)
+	if changed {
+		clearVocabulary(c.c, key)
 	}
 	return changed
 }
 
+// check if the lists have the same strings in the same order
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, _ := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// remove a dish, ingredient or menu from the cache, along with its
+//  tags, keywords and the lists that include it
+func clearItemCache(c *context, key *datastore.Key) {
+	path := c.lid.Encode() + "/" + strings.ToLower(key.Kind())
+	itemPath := path + "/" + key.Encode()
+	memcache.DeleteMulti(c.c, []string{
+		itemPath,
+		itemPath + "/tags/",
+		itemPath + "/keywords/",
+		path + "/",
+		path,
+	})
+}
+
 // handler for requests to get the measured ingredients of a dish (parent)
 func measuredIngredientsHandler(c *context) {
 	// get the dish's id and verify it
@@ -343,17 +490,6 @@ func dishesForIngredientHandler(c *context) {
 	}
 }
 
-// handler to access tags and keyword children of an item (parent)
-func wordHandler(c *context, kind string) {
-	// validate the parent Key
-	parentKey, err := datastore.DecodeKey(getParentID(c.r))
-	check(err)
-	c.checkUser(parentKey)
-	// use the default handler
-	handler := newDataHandler(c, kind, func() Ided { return &Word{} }, "")
-	handler.handleRequest(parentKey, nil)
-}
-
 // handler to access pairings as children of a dish (parent)
 func pairingHandler(c *context) {
 	// validate the dish key
@@ -362,50 +498,283 @@ func pairingHandler(c *context) {
 	c.checkUser(parentKey)
 	kind := "Pairing"
 	handler := newDataHandler(c, kind, func() Ided { return &Pairing{} }, "")
-	// we don't use the standard handlers for PUT and DELETE
+	// we only use the standard handler for GET, the others need to keep
+	//  the twin pairing under the other dish in sync
 	switch c.r.Method {
+	case "POST":
+		if len(getID(c.r)) != 0 {
+			check(ErrUnsupported)
+		}
+		pairing := &Pairing{}
+		readJSON(c.r, pairing)
+		addPairing(c, parentKey, pairing)
+		clearPairingCache(c, parentKey, nil)
+		handler.sendJSON(pairing)
 	case "PUT":
-		// can't modify a pairing, only add/remove
-		check(ErrUnsupported)
+		// validate the id
+		key, err := datastore.DecodeKey(getID(c.r))
+		check(err)
+		handler.checkUser(key)
+		// only the notes and strength can be changed, changing
+		//  the type or dish is done by removing and adding
+		pairing := &Pairing{}
+		err = datastore.Get(c.c, key, pairing)
+		check(err)
+		changes := &Pairing{}
+		readJSON(c.r, changes)
+		checkPairingStrength(changes.Strength)
+		pairing.Notes = changes.Notes
+		pairing.Strength = changes.Strength
+		_, err = datastore.Put(c.c, key, pairing)
+		check(err)
+		// update the twin pairing(s) to match
+		for _, twinKey := range findTwinPairings(c, parentKey, pairing) {
+			twin := Pairing{}
+			err = datastore.Get(c.c, twinKey, &twin)
+			check(err)
+			twin.Notes = pairing.Notes
+			twin.Strength = pairing.Strength
+			_, err = datastore.Put(c.c, twinKey, &twin)
+			check(err)
+			clearPairingCache(c, pairing.Other, twinKey)
+		}
+		clearPairingCache(c, parentKey, key)
+		pairing.SetID(key.Encode())
+		handler.sendJSON(pairing)
 	case "DELETE":
 		// validate the id
 		id := getID(c.r)
 		key, err := datastore.DecodeKey(id)
 		check(err)
 		handler.checkUser(key)
-		// find the symetrical pairing so we can remove it too
+		// find the twin pairing so we can remove it too
 		pairing := Pairing{}
 		err = datastore.Get(c.c, key, &pairing)
 		check(err)
 		otherParent := pairing.Other
-		query := datastore.NewQuery(kind).Ancestor(otherParent).Filter("Other=", parentKey).Filter("Description=", pairing.Description).KeysOnly()
-		keys, err := query.GetAll(c.c, nil)
-		check(err)
+		keys := findTwinPairings(c, parentKey, &pairing)
 		// delete the entry given
 		handler.delete(key)
-		// delete the symetrical pairing(s)
+		// delete the twin pairing(s)
 		datastore.DeleteMulti(handler.c, keys)
 		// flush the cache
-		clearPairingCache(c, otherParent, key)
+		clearPairingCache(c, parentKey, key)
+		for _, twinKey := range keys {
+			clearPairingCache(c, otherParent, twinKey)
+		}
 	default:
-		// use the default handler for "GET" and "POST"
-		handler.handleRequest(parentKey,
-			func(method string, key *datastore.Key, item Ided) {
-				switch method {
-				case "POST":
-					// after a "POST" to create a new item,
-					//  add the symetrical entry for the other dish
-					pairing := item.(*Pairing)
-					// create the matching entry
-					other := pairing.Other
-					newPairKey := datastore.NewIncompleteKey(c.c, kind, other)
-					pairing.Other = parentKey
-					pairing.Id = ""
-					newPairKey, err := datastore.Put(c.c, newPairKey, pairing)
-					check(err)
-					clearPairingCache(c, other, nil)
+		// use the default handler for "GET"
+		handler.handleRequest(parentKey, nil)
+	}
+}
+
+// validate and store a new pairing under the parent dish, adding the
+//  twin pairing under the other dish if the type has an inverse
+// returns the key of the new pairing
+func addPairing(c *context, parentKey *datastore.Key, pairing *Pairing) *datastore.Key {
+	// validate the pairing
+	if pairing.Other == nil || pairing.Other.Equal(parentKey) {
+		check(ErrInvalidPairing)
+	}
+	c.checkUser(pairing.Other)
+	inverse, found := getPairingInverses(c)[pairing.Description]
+	if !found {
+		check(ErrInvalidPairing)
+	}
+	checkPairingStrength(pairing.Strength)
+	// store the pairing
+	pairing.Id = ""
+	key := datastore.NewIncompleteKey(c.c, "Pairing", parentKey)
+	key, err := datastore.Put(c.c, key, pairing)
+	check(err)
+	pairing.SetID(key.Encode())
+	// create the twin entry for the other dish
+	if len(inverse) > 0 {
+		twin := Pairing{
+			Other:       parentKey,
+			Description: inverse,
+			Notes:       pairing.Notes,
+			Strength:    pairing.Strength,
+		}
+		twinKey := datastore.NewIncompleteKey(c.c, "Pairing", pairing.Other)
+		_, err = datastore.Put(c.c, twinKey, &twin)
+		check(err)
+		clearPairingCache(c, pairing.Other, nil)
+	}
+	return key
+}
+
+// panic if the strength of a pairing isn't valid
+func checkPairingStrength(strength int) {
+	if strength < 0 || strength > 5 {
+		check(ErrInvalidPairing)
+	}
+}
+
+// find the keys of the twin(s) of the pairing stored under the other dish
+func findTwinPairings(c *context, parentKey *datastore.Key, pairing *Pairing) []*datastore.Key {
+	inverse, found := getPairingInverses(c)[pairing.Description]
+	if !found {
+		// pairings of types no longer in the library are treated as symmetric
+		inverse = pairing.Description
+	}
+	if len(inverse) == 0 {
+		return nil
+	}
+	query := datastore.NewQuery("Pairing").Ancestor(pairing.Other).Filter("Other=", parentKey).Filter("Description=", inverse).KeysOnly()
+	keys, err := query.GetAll(c.c, nil)
+	check(err)
+	return keys
+}
+
+// the pairing types a library starts with
+func defaultPairingTypes() []PairingType {
+	return []PairingType{
+		{Name: "Together", Inverse: "Together"},
+		{Name: "Alternative", Inverse: "Alternative"},
+		{Name: "Substitute", Inverse: "Substitute"},
+		{Name: "Side for", Inverse: "Has side"},
+		{Name: "Sauce for", Inverse: "Has sauce"},
+		{Name: "Wine with", Inverse: "Has wine"},
+	}
+}
+
+// get the pairing types of the library, or the defaults if it has none
+func getPairingTypes(c *context) []PairingType {
+	types := make([]PairingType, 0, 20)
+	_, err := c.NewQuery("PairingType").GetAll(c.c, &types)
+	check(err)
+	if len(types) == 0 {
+		return defaultPairingTypes()
+	}
+	return types
+}
+
+// build a map from each pairing type name to the name of its inverse
+//  the inverse of a directional type also maps back to the type
+func getPairingInverses(c *context) map[string]string {
+	inverses := make(map[string]string)
+	types := getPairingTypes(c)
+	for _, pairingType := range types {
+		inverses[pairingType.Name] = pairingType.Inverse
+	}
+	for _, pairingType := range types {
+		if _, found := inverses[pairingType.Inverse]; !found && len(pairingType.Inverse) > 0 {
+			inverses[pairingType.Inverse] = pairingType.Name
+		}
+	}
+	return inverses
+}
+
+// store the default pairing types if the library has none, so the
+//  user can change them
+func seedPairingTypes(c *context) {
+	keys, err := c.NewQuery("PairingType").KeysOnly().GetAll(c.c, nil)
+	check(err)
+	if len(keys) > 0 {
+		return
+	}
+	defaults := defaultPairingTypes()
+	putItems := make([]interface{}, 0, len(defaults))
+	putKeys := make([]*datastore.Key, 0, len(defaults))
+	for i, _ := range defaults {
+		putItems = append(putItems, &defaults[i])
+		putKeys = append(putKeys, datastore.NewIncompleteKey(c.c, "PairingType", c.lid))
+	}
+	_, err = datastore.PutMulti(c.c, putKeys, putItems)
+	check(err)
+	memcache.DeleteMulti(c.c, []string{c.lid.Encode() + "/pairingtype/",
+		c.lid.Encode() + "/pairingtype"})
+}
+
+// whether pairings of the type have a twin: "symmetric" types are
+//  their own inverse, "directional" types have a different inverse
+//  and "one-way" types have none
+func (self *PairingType) direction() string {
+	switch self.Inverse {
+	case "":
+		return "one-way"
+	case self.Name:
+		return "symmetric"
+	}
+	return "directional"
+}
+
+// handler for the pairing types of the library
+//  renaming or deleting a type changes or removes the pairings of that
+//  type, a type can't be changed between symmetric, directional and
+//  one-way as the twins of its pairings would no longer match
+func pairingTypeHandler(c *context) {
+	var old *PairingType
+	if id := getID(c.r); len(id) > 0 && (c.r.Method == "PUT" || c.r.Method == "DELETE") {
+		key, err := datastore.DecodeKey(id)
+		check(err)
+		c.checkUser(key)
+		old = &PairingType{}
+		err = datastore.Get(c.c, key, old)
+		if err == datastore.ErrNoSuchEntity {
+			check(ErrUnknownItem)
+		}
+		check(err)
+	}
+	// check the type before it is saved
+	if c.r.Method == "POST" || c.r.Method == "PUT" {
+		body, err := ioutil.ReadAll(c.r.Body)
+		check(err)
+		pairingType := &PairingType{}
+		check(json.Unmarshal(body, pairingType))
+		if len(strings.TrimSpace(pairingType.Name)) == 0 {
+			check(ErrInvalidPairing)
+		}
+		if old != nil && old.direction() != pairingType.direction() {
+			check(ErrInvalidPairing)
+		}
+		c.r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	// use the default data handler, with a callback to update the
+	//  pairings of the type
+	handler := newDataHandler(c, "PairingType", func() Ided { return &PairingType{} }, "Name")
+	handler.handleRequest(c.lid,
+		func(method string, key *datastore.Key, item Ided) {
+			switch method {
+			case "PUT":
+				pairingType := item.(*PairingType)
+				renames := map[string]string{old.Name: pairingType.Name}
+				if old.direction() == "directional" {
+					renames[old.Inverse] = pairingType.Inverse
+				}
+				for from, to := range renames {
+					if from != to {
+						updatePairingsOfType(c, from, to)
+					}
+				}
+			case "DELETE":
+				updatePairingsOfType(c, old.Name, "")
+				if old.direction() == "directional" {
+					updatePairingsOfType(c, old.Inverse, "")
 				}
-			})
+			}
+		})
+}
+
+// change the Description of the pairings in the library of the type
+//  from to the type to, or delete them if to is empty
+func updatePairingsOfType(c *context, from, to string) {
+	pairings := make([]Pairing, 0, 100)
+	keys, err := c.NewQuery("Pairing").Filter("Description =", from).GetAll(c.c, &pairings)
+	check(err)
+	if len(to) == 0 {
+		deleteMulti(c.c, keys)
+	} else {
+		putItems := make([]interface{}, len(pairings))
+		for i, _ := range pairings {
+			pairings[i].Description = to
+			putItems[i] = &pairings[i]
+		}
+		putMulti(c.c, keys, putItems)
+	}
+	for _, key := range keys {
+		clearPairingCache(c, key.Parent(), key)
 	}
 }
 
@@ -428,21 +797,12 @@ func ingredientHandler(c *context) {
 	}
 	// handler for tags
 	if strings.Contains(c.r.URL.Path, "/tags/") {
-		wordHandler(c, "Tags")
-		// update keywords if tags were changed
-		if c.r.Method != "GET" {
-			key, err := datastore.DecodeKey(getParentID(c.r))
-			check(err)
-			ingredient := Ingredient{}
-			err = datastore.Get(c.c, key, &ingredient)
-			check(err)
-			updateIngredientKeywords(c, key, &ingredient)
-		}
+		tagsHandler(c)
 		return
 	}
 	// handler for debugging keywords
 	if strings.Contains(c.r.URL.Path, "/keywords/") {
-		wordHandler(c, "Keyword")
+		keywordsHandler(c)
 		return
 	}
 	// use default data handler with callback when done
@@ -452,21 +812,55 @@ func ingredientHandler(c *context) {
 			switch method {
 			case "POST", "PUT":
 				// update keywords after adding/changing an item
-				updateIngredientKeywords(c, key, item.(*Ingredient))
+				queueIndex(c, key)
+			case "DELETE":
+				// stop suggesting the name of the ingredient
+				query := datastore.NewQuery("Prefix").Ancestor(key).KeysOnly()
+				keys, err := query.GetAll(c.c, nil)
+				check(err)
+				datastore.DeleteMulti(c.c, keys)
 			}
 		})
 }
 
 // handler for menu requests
 func menuHandler(c *context) {
+	// handle the summary of the menu's balance
+	if strings.HasSuffix(c.r.URL.Path, "/summary") {
+		menuSummaryHandler(c)
+		return
+	}
 	// handle tags 
 	if strings.Contains(c.r.URL.Path, "/tags/") {
-		wordHandler(c, "Tags")
+		tagsHandler(c)
 		return
 	}
-	// use default data handler
+	// for debugging, get keywords
+	if strings.Contains(c.r.URL.Path, "/keywords/") {
+		keywordsHandler(c)
+		return
+	}
+	// check every item has a dish from this library before the menu
+	//  is saved
+	if c.r.Method == "POST" || c.r.Method == "PUT" {
+		body, err := ioutil.ReadAll(c.r.Body)
+		check(err)
+		menu := &Menu{}
+		check(json.Unmarshal(body, menu))
+		menu.checkItems(c)
+		c.r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	// use default data handler, with a callback to keep the keywords
+	//  up to date
 	handler := newDataHandler(c, "Menu", func() Ided { return &Menu{} }, "Name")
-	handler.handleRequest(c.lid, nil)
+	handler.handleRequest(c.lid,
+		func(method string, key *datastore.Key, item Ided) {
+			switch method {
+			case "POST", "PUT":
+				// update keyword index after a change
+				queueIndex(c, key)
+			}
+		})
 }
 
 // helper function for decoding json checking for errors
@@ -495,6 +889,17 @@ func getParentID(r *http.Request) string {
 	return parts[len(parts)-3]
 }
 
+// helper to fetch the ID of the item an action applies to
+// returns 2nd to last path part, e.g. /menu/<id>/summary
+// will return "<id>"
+func getActionID(r *http.Request) string {
+	parts := strings.Split(strings.TrimRight(r.URL.Path, "/"), "/")
+	if len(parts) < 4 {
+		return ""
+	}
+	return parts[len(parts)-2]
+}
+
 // create a new context to wrap data
 //  creates a new library if user has none
 //  sets the context if user wants to view a library othe than their own
@@ -547,6 +952,10 @@ func newContext(w http.ResponseWriter, r *http.Request) *context {
 	}
 	// create the context with all of the data we gathered
 	ctxt := &context{w, r, c, u, uid, l, lid, readOnly}
+	// upgrade libraries saved by older versions
+	if !init && l.Version < libraryVersion {
+		migrateLibrary(ctxt)
+	}
 	// if this is a new library, populate it with data
 	if init {
 		file, err := os.Open("mealplanner/base.json")
@@ -556,6 +965,7 @@ func newContext(w http.ResponseWriter, r *http.Request) *context {
 		if err == nil {
 			importFile(ctxt, file)
 		}
+		seedPairingTypes(ctxt)
 	}
 	return ctxt
 }
@@ -757,6 +1167,11 @@ func (self *dataHandler) createEntry(parent *datastore.Key) (*datastore.Key, Ide
 	item := self.factory()
 	// read the JSON from client
 	readJSON(r, item)
+	// the keywords will be found in the background
+	if tagged, ok := item.(taggedItem); ok {
+		tagged.setKeywords(nil)
+		tagged.setIndexStatus(indexPending)
+	}
 	// create a new datastore key
 	key := datastore.NewIncompleteKey(c, self.kind, parent)
 	// save the new item
@@ -795,6 +1210,22 @@ func (self *dataHandler) update(key *datastore.Key) Ided {
 	readJSON(self.r, object)
 	// don't let user change the ID
 	object.SetID(key.Encode())
+	// tags are changed through their own URL and keywords by the
+	//  server, keep what is stored, the keywords will be updated
+	//  in the background
+	if tagged, ok := object.(taggedItem); ok {
+		stored := self.factory().(taggedItem)
+		err := datastore.Get(self.c, key, stored)
+		check(err)
+		tagged.setTags(stored.tags())
+		tagged.setKeywords(stored.keywords())
+		tagged.setIndexStatus(indexPending)
+		// likewise the cook history of a dish, see cookedHandler
+		if dish, ok := object.(*Dish); ok {
+			dish.CookedCount = stored.(*Dish).CookedCount
+			dish.LastCooked = stored.(*Dish).LastCooked
+		}
+	}
 	// save to the datastore
 	_, err := datastore.Put(self.c, key, object)
 	check(err)
@@ -834,7 +1265,7 @@ func getOwnLibrary(c appengine.Context, u *user.User) (*datastore.Key, *Library,
 	if err == memcache.ErrCacheMiss {
 		err = datastore.Get(c, lid, l)
 		if err == datastore.ErrNoSuchEntity {
-			l = &Library{uid, 0, u.String(), ""}
+			l = &Library{uid, libraryVersion, u.String(), "", ""}
 			lid, err = datastore.Put(c, lid, l)
 			check(err)
 			init = true
@@ -863,153 +1294,25 @@ func getLibPerm(c appengine.Context, uid string, libKey *datastore.Key) *Perm {
 	return perm
 }
 
-// structure to read search parameters from client's post
-type searchParams struct {
-	// list of tags that must match
-	Tags []string
-	// space/comma separated list of keywords to search for
-	Word string
-}
-
-// handler for search requests, client "POST"s searchParams as JSON
-func searchHandler(c *context) {
-	// decode the JSON search parameters
-	sp := searchParams{}
-	readJSON(c.r, &sp)
-	// create a channel for goroutines to send back search results
-	resultsChannel := make(chan map[string]map[string]uint)
-	// count how many goroutines we start so we read all the results back from the channel
-	var queries uint = 0
-
-	// start a goroutine to search for items each specified tag
-	// the merge will do the "and" operation to constrain them
-	if len(sp.Tags) > 0 {
-		for _, target := range sp.Tags {
-			word := target
-			go func() {
-				// fetch the tags that match 
-				query := c.NewQuery("Tags").KeysOnly()
-				query.Filter("Word=", word)
-				keys, err := query.GetAll(c.c, nil)
-				check(err)
-				results := make(map[string]map[string]uint)
-				addResults(keys, results)
-				// send the results through the channel
-				resultsChannel <- results
-			}()
-			queries++
-		}
-	}
-
-	// handle word search
-	if len(sp.Word) > 0 {
-		go func() {
-			results := make(map[string]map[string]uint)
-			// break the query into words
-			terms := make(map[string]bool)
-			addWords(sp.Word, terms)
-			// search for each word
-			for target, _ := range terms {
-				query := c.NewQuery("Keyword").Filter("Word=", target).KeysOnly()
-				keys, err := query.GetAll(c.c, nil)
-				check(err)
-				addResults(keys, results)
-			}
-			if ings, ok := results["Ingredient"]; ok {
-				for ing, _ := range ings {
-					ingKey, err := datastore.DecodeKey(ing)
-					check(err)
-					// carry results forward to list dishes that have the ingredients that matched
-					query := c.NewQuery("MeasuredIngredient").Filter("Ingredient =", ingKey).KeysOnly()
-					keys, err := query.GetAll(c.c, nil)
-					check(err)
-					addResults(keys, results)
-				}
+// handler to get the tree of all tags in this library
+func allTagsHandler(c *context) {
+	found := make(map[string]bool)
+	for _, kind := range searchKinds {
+		iter := c.NewQuery(kind).Run(c.c)
+		item := newTaggedItem(kind)
+		for _, err := iter.Next(item); err != datastore.Done; _, err = iter.Next(item) {
+			check(err)
+			for _, tag := range item.tags() {
+				found[tag] = true
 			}
-			// send the results through the channel
-			resultsChannel <- results
-		}()
-		queries++
-	}
-
-	// merge the results from all the queries
-	results := mergeResults(resultsChannel, queries)
-	c.sendJSONNoCache(results)
-}
-
-// loop through the results, adding them to a two level map keyed on the entity
-//  kind (Dish,Ingredient) and then the item itself, counting how many
-// times we come across each item
-func addResult(key *datastore.Key, results map[string]map[string]uint) {
-	parent := key.Parent()
-	parentEnc := parent.Encode()
-	if kind, ok := results[parent.Kind()]; ok {
-		if _, ok := kind[parentEnc]; ok {
-			kind[parentEnc] += 1
-		} else {
-			kind[parentEnc] = 1
+			item = newTaggedItem(kind)
 		}
-	} else {
-		results[parent.Kind()] = make(map[string]uint)
-		results[parent.Kind()][parentEnc] = 1
 	}
-}
-
-// add results as above for multiple items
-func addResults(keys []*datastore.Key, results map[string]map[string]uint) {
-	for _, key := range keys {
-		addResult(key, results)
-	}
-}
-
-// take the results from /count/ queries from the /resultsChannel/
-//  and perform an intersection
-func mergeResults(resultsChannel chan map[string]map[string]uint,
-	count uint) map[string]map[string]uint {
-	if count == 0 {
-		return make(map[string]map[string]uint)
-	}
-	// start with the first map we get
-	results := <-resultsChannel
-	// for each additional set we get, make a new map
-	// keeping only items that appear in both results
-	count--
-	for count > 0 {
-		results2 := <-resultsChannel
-		results1 := results
-		results = make(map[string]map[string]uint)
-		for kind, ids1 := range results1 {
-			if ids2, ok := results2[kind]; ok {
-				ids := make(map[string]uint)
-				for id1, cnt1 := range ids1 {
-					if cnt2, ok := ids2[id1]; ok {
-						ids[id1] = cnt1 + cnt2
-					}
-				}
-				results[kind] = ids
-			}
-		}
-		count--
+	tags := make([]string, 0, len(found))
+	for tag, _ := range found {
+		tags = append(tags, tag)
 	}
-	return results
-}
-
-// handler to get all tags in this library
-func allTagsHandler(c *context) {
-	tags := make([]string, 0, 100)
-	// order by the tag name, so we can skip duplicates
-	query := c.NewQuery("Tags").Order("Word")
-	results := make([]Word, 0, 100)
-	_, err := query.GetAll(c.c, &results)
-	check(err)
-	lastTag := ""
-	for _, tag := range results {
-		if tag.Word != lastTag {
-			tags = append(tags, tag.Word)
-			lastTag = tag.Word
-		}
-	}
-	c.sendJSONNoCache(tags)
+	c.sendJSONNoCache(tagTree(tags))
 }
 
 // handler to create JSON to backup all data in the current library
@@ -1017,7 +1320,6 @@ func backupHandler(c *context) {
 	// initialize the backup structures
 	b := backup{}
 	b.MeasuredIngredients = map[string][]MeasuredIngredient{}
-	b.Tags = map[string][]Word{}
 	b.Pairings = map[string][]Pairing{}
 
 	// gather all the dishes
@@ -1036,35 +1338,14 @@ func backupHandler(c *context) {
 		key := keys[i]
 		b.Ingredients[i].Id = key.Encode()
 	}
-	// gather all the tags
-	tags := make([]Word, 0, 500)
-	query = c.NewQuery("Tags")
-	tkeys, err := query.GetAll(c.c, &tags)
-	check(err)
-	first := 0
-	var lastParent *datastore.Key = nil
-	// fix-up each tags' ID and after we've found
-	//  all tags belonging to a single parent, save
-	//  that slice of tags to the map
-	for i, _ := range tags {
-		parent := tkeys[i].Parent()
-		if !parent.Equal(lastParent) {
-			if lastParent != nil {
-				b.Tags[lastParent.Encode()] = tags[first:i]
-			}
-			lastParent, first = parent, i
-		}
-		tags[i].SetID(tkeys[i].Encode())
-	}
-	if lastParent != nil {
-		b.Tags[lastParent.Encode()] = tags[first:]
-	}
+	// the tags are kept with the items
 	// gather the measured ingredients
 	mis := make([]MeasuredIngredient, 0, 512)
 	query = c.NewQuery("MeasuredIngredient")
 	ikeys, err := query.GetAll(c.c, &mis)
 	check(err)
-	lastParent, first = nil, 0
+	first := 0
+	var lastParent *datastore.Key = nil
 	for i, _ := range mis {
 		parent := ikeys[i].Parent()
 		if !parent.Equal(lastParent) {
@@ -1097,6 +1378,20 @@ func backupHandler(c *context) {
 	if lastParent != nil {
 		b.Pairings[lastParent.Encode()] = pairings[first:]
 	}
+	// gather the pairing types
+	query = c.NewQuery("PairingType")
+	keys, err = query.GetAll(c.c, &b.PairingTypes)
+	check(err)
+	for i, _ := range b.PairingTypes {
+		b.PairingTypes[i].SetID(keys[i].Encode())
+	}
+	// gather the saved searches
+	query = c.NewQuery("SavedSearch")
+	keys, err = query.GetAll(c.c, &b.SavedSearches)
+	check(err)
+	for i, _ := range b.SavedSearches {
+		b.SavedSearches[i].SetID(keys[i].Encode())
+	}
 	// gather the menus
 	query = c.NewQuery("Menu")
 	keys, err = query.GetAll(c.c, &b.Menus)
@@ -1104,6 +1399,8 @@ func backupHandler(c *context) {
 	for i, _ := range b.Menus {
 		key := keys[i]
 		b.Menus[i].SetID(key.Encode())
+		// always write the dishes as MenuItems
+		b.Menus[i].migrateDishes()
 	}
 	c.sendJSONIndent(b)
 }
@@ -1211,11 +1508,21 @@ type UserLibrary struct {
 
 // return a list of libraries this user can access
 func librariesHandler(c *context) {
+	libraries, _ := userLibraries(c)
+	// send JSON to the client
+	c.sendJSONNoCache(libraries)
+}
+
+// find the libraries this user can access, their own library first
+//  returns the client's structure for each library and the library itself
+func userLibraries(c *context) ([]UserLibrary, []*Library) {
 	// start with the library the user owns
 	lid, l, _ := getOwnLibrary(c.c, c.u)
 	uid := c.getUid()
 	libraries := make([]UserLibrary, 0, 10)
 	libraries = append(libraries, UserLibrary{lid, l.Name, false, lid.Equal(c.lid), true})
+	libs := make([]*Library, 0, 10)
+	libs = append(libs, l)
 	// look for any permissions the user has to access other libraries
 	query := datastore.NewQuery("Perm").Filter("UserId=", uid)
 	perm := Perm{}
@@ -1223,9 +1530,9 @@ func librariesHandler(c *context) {
 	for key, err := iter.Next(&perm); err != datastore.Done; key, err = iter.Next(&perm) {
 		check(err)
 		// fetch libraries that we find
-		lib := Library{}
+		lib := &Library{}
 		libkey := key.Parent()
-		err = datastore.Get(c.c, libkey, &lib)
+		err = datastore.Get(c.c, libkey, lib)
 		if err != nil {
 			continue
 		}
@@ -1237,9 +1544,9 @@ func librariesHandler(c *context) {
 			ul.Name = lib.OwnerId
 		}
 		libraries = append(libraries, ul)
+		libs = append(libs, lib)
 	}
-	// send JSON to the client
-	c.sendJSONNoCache(libraries)
+	return libraries, libs
 }
 
 // handler to switch which library the user is looking at
@@ -1280,7 +1587,7 @@ func switchHandler(c *context) {
 
 // handler to delete entire library
 func deletelibHandler(c *context) {
-	for _, kind := range []string{"Keyword", "Tags", "Pairing", "Menu", "MeasuredIngredient", "Dish", "Ingredient"} {
+	for _, kind := range []string{"Keyword", "Tags", "Pairing", "Menu", "MeasuredIngredient", "Dish", "Ingredient", "MenuTargets", "PairingType", "Prefix", "SavedSearch"} {
 		query := c.NewQuery(kind).KeysOnly()
 		dkeys, err := query.GetAll(c.c, nil)
 		if err == nil {
@@ -0,0 +1,211 @@
+package mealplanner
+
+// handlers summarizing the balance of a menu
+
+import (
+	"appengine/datastore"
+	"fmt"
+)
+
+// summary of a menu sent to the client as JSON
+type menuSummary struct {
+	// Id of the menu summarized
+	Id string
+	// Name of the menu summarized
+	Name string
+	// total servings of carbohydrates across the dishes of the menu
+	ServingsCarb float32
+	// total servings of protein across the dishes of the menu
+	ServingsProtein float32
+	// total servings of veggetables across the dishes of the menu
+	ServingsVeggies float32
+	// time spent preparing all of the dishes
+	PrepTimeMinutes int
+	// time to prepare and cook all of the dishes one after another
+	TotalTimeMinutes int
+	// time to prepare and cook the menu if the dishes are made in parallel,
+	//  that is the time of the longest dish
+	CriticalPathMinutes int
+	// the DishTypes that appear in the menu
+	DishTypes []string
+	// the DishTypes from the targets that don't appear in the menu
+	MissingDishTypes []string
+	// the targets the menu was compared against
+	Targets *MenuTargets
+	// descriptions of how the menu is out of balance
+	Warnings []string
+}
+
+// the targets used if the library hasn't configured its own
+//  these match the "Target" chart drawn by the client
+func defaultMenuTargets() *MenuTargets {
+	return &MenuTargets{
+		ServingsCarb:    1,
+		ServingsProtein: 1,
+		ServingsVeggies: 2,
+		Tolerance:       0.5,
+		DishTypes:       []string{"Entree", "Side"},
+	}
+}
+
+// get the key for the menu targets of the current library
+func (self *context) menuTargetsKey() *datastore.Key {
+	return datastore.NewKey(self.c, "MenuTargets", "menu", 0, self.lid)
+}
+
+// fetch the menu targets for the current library, using the defaults
+//  if none have been saved
+func getMenuTargets(c *context) *MenuTargets {
+	targets := &MenuTargets{}
+	err := datastore.Get(c.c, c.menuTargetsKey(), targets)
+	if err == datastore.ErrNoSuchEntity {
+		return defaultMenuTargets()
+	}
+	check(err)
+	return targets
+}
+
+// handler to get or update the menu targets of the library
+func targetsHandler(c *context) {
+	switch c.r.Method {
+	case "GET":
+		c.sendJSONNoCache(getMenuTargets(c))
+	case "PUT":
+		targets := &MenuTargets{}
+		readJSON(c.r, targets)
+		if targets.Tolerance < 0 {
+			check(ErrUnsupported)
+		}
+		_, err := datastore.Put(c.c, c.menuTargetsKey(), targets)
+		check(err)
+		c.sendJSONNoCache(targets)
+	default:
+		check(ErrUnsupported)
+	}
+}
+
+// handler to summarize the balance of a menu, /menu/<id>/summary
+func menuSummaryHandler(c *context) {
+	if c.r.Method != "GET" {
+		check(ErrUnsupported)
+	}
+	// validate the menu key
+	key, err := datastore.DecodeKey(getActionID(c.r))
+	check(err)
+	if key.Kind() != "Menu" {
+		check(ErrUnknownItem)
+	}
+	c.checkUser(key)
+	menu := &Menu{}
+	err = datastore.Get(c.c, key, menu)
+	check(err)
+	dishKeys := make([]*datastore.Key, len(menu.Items))
+	multipliers := make([]float32, len(menu.Items))
+	for i, _ := range menu.Items {
+		dishKeys[i] = menu.Items[i].Dish
+		multipliers[i] = menu.Items[i].Multiplier()
+	}
+	summary := summarizeDishKeys(c, dishKeys, multipliers)
+	summary.Id = key.Encode()
+	summary.Name = menu.Name
+	c.sendJSONNoCache(summary)
+}
+
+// fetch the dishes and summarize them against the library's targets,
+//  skipping any dishes that have been removed
+func summarizeDishKeys(c *context, dishKeys []*datastore.Key, multipliers []float32) *menuSummary {
+	// items of old menus may have lost their dish
+	keys := make([]*datastore.Key, 0, len(dishKeys))
+	keyMultipliers := make([]float32, 0, len(dishKeys))
+	for i, dishKey := range dishKeys {
+		if dishKey != nil {
+			keys = append(keys, dishKey)
+			keyMultipliers = append(keyMultipliers, multipliers[i])
+		}
+	}
+	loaded := make([]Dish, len(keys))
+	errs := getMulti(c.c, keys, loaded)
+	dishes := make([]*Dish, 0, len(keys))
+	found := make([]float32, 0, len(keys))
+	for i, _ := range keys {
+		if errs[i] == datastore.ErrNoSuchEntity {
+			continue
+		}
+		check(errs[i])
+		dishes = append(dishes, &loaded[i])
+		found = append(found, keyMultipliers[i])
+	}
+	return summarizeMenu(dishes, found, getMenuTargets(c))
+}
+
+// add up the servings and times of the dishes and compare them
+//  against the targets, the servings of each dish are scaled by
+//  the matching entry in multipliers
+func summarizeMenu(dishes []*Dish, multipliers []float32, targets *MenuTargets) *menuSummary {
+	summary := &menuSummary{
+		DishTypes:        make([]string, 0, len(dishes)),
+		MissingDishTypes: make([]string, 0, len(targets.DishTypes)),
+		Targets:          targets,
+		Warnings:         make([]string, 0, 4),
+	}
+	covered := make(map[string]bool)
+	for i, dish := range dishes {
+		summary.ServingsCarb += dish.ServingsCarb * multipliers[i]
+		summary.ServingsProtein += dish.ServingsProtein * multipliers[i]
+		summary.ServingsVeggies += dish.ServingsVeggies * multipliers[i]
+		summary.PrepTimeMinutes += dish.PrepTimeMinutes
+		minutes := dish.PrepTimeMinutes + dish.CookTimeMinutes
+		summary.TotalTimeMinutes += minutes
+		if minutes > summary.CriticalPathMinutes {
+			summary.CriticalPathMinutes = minutes
+		}
+		if len(dish.DishType) > 0 && !covered[dish.DishType] {
+			covered[dish.DishType] = true
+			summary.DishTypes = append(summary.DishTypes, dish.DishType)
+		}
+	}
+	for _, dishType := range targets.DishTypes {
+		if !covered[dishType] {
+			summary.MissingDishTypes = append(summary.MissingDishTypes, dishType)
+			summary.Warnings = append(summary.Warnings,
+				fmt.Sprintf("No %v dish", dishType))
+		}
+	}
+	summary.checkServings("carbohydrates", summary.ServingsCarb, targets.ServingsCarb, targets.Tolerance)
+	summary.checkServings("protein", summary.ServingsProtein, targets.ServingsProtein, targets.Tolerance)
+	summary.checkServings("fruits and vegetables", summary.ServingsVeggies, targets.ServingsVeggies, targets.Tolerance)
+	return summary
+}
+
+// add a warning if the servings are too far from the target
+//  a target of 0 means there is no target
+func (self *menuSummary) checkServings(name string, servings, target, tolerance float32) {
+	if target <= 0 {
+		return
+	}
+	switch {
+	case servings < target*(1-tolerance):
+		self.Warnings = append(self.Warnings,
+			fmt.Sprintf("Too few servings of %v (%v of %v)", name, servings, target))
+	case servings > target*(1+tolerance):
+		self.Warnings = append(self.Warnings,
+			fmt.Sprintf("Too many servings of %v (%v of %v)", name, servings, target))
+	}
+}
+
+// check that each item of the menu refers to a dish in the current
+//  library, panics with ErrUnknownItem if one doesn't
+func (self *Menu) checkItems(c *context) {
+	for _, item := range self.Items {
+		if item.Dish == nil || item.Dish.Kind() != "Dish" {
+			check(ErrUnknownItem)
+		}
+		c.checkUser(item.Dish)
+	}
+	for _, dishKey := range self.Dishes {
+		if dishKey == nil || dishKey.Kind() != "Dish" {
+			check(ErrUnknownItem)
+		}
+		c.checkUser(dishKey)
+	}
+}
@@ -0,0 +1,86 @@
+package mealplanner
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSummarizeMenu(t *testing.T) {
+	targets := defaultMenuTargets()
+	chicken := &Dish{DishType: "Entree", ServingsProtein: 1, PrepTimeMinutes: 10, CookTimeMinutes: 30}
+	rice := &Dish{DishType: "Side", ServingsCarb: 1, PrepTimeMinutes: 5, CookTimeMinutes: 20}
+	salad := &Dish{DishType: "Side", ServingsVeggies: 2, PrepTimeMinutes: 15}
+	tests := []struct {
+		dishes      []*Dish
+		multipliers []float32
+		carb        float32
+		protein     float32
+		veggies     float32
+		prep        int
+		total       int
+		critical    int
+		dishTypes   []string
+		missing     []string
+		warnings    int
+	}{
+		// an empty menu misses everything
+		{[]*Dish{}, []float32{}, 0, 0, 0, 0, 0, 0,
+			[]string{}, []string{"Entree", "Side"}, 5},
+		// a balanced menu
+		{[]*Dish{chicken, rice, salad}, []float32{1, 1, 1}, 1, 1, 2, 30, 80, 40,
+			[]string{"Entree", "Side"}, []string{}, 0},
+		// servings are weighted by the multipliers, times aren't
+		{[]*Dish{chicken, rice, salad}, []float32{2, 0.5, 1}, 0.5, 2, 2, 30, 80, 40,
+			[]string{"Entree", "Side"}, []string{}, 1},
+		{[]*Dish{chicken, salad}, []float32{1, 1.5}, 0, 1, 3, 25, 55, 40,
+			[]string{"Entree", "Side"}, []string{}, 1},
+	}
+	for i, test := range tests {
+		s := summarizeMenu(test.dishes, test.multipliers, targets)
+		if s.ServingsCarb != test.carb || s.ServingsProtein != test.protein ||
+			s.ServingsVeggies != test.veggies {
+			t.Errorf("%d: servings = %v %v %v, want %v %v %v", i, s.ServingsCarb,
+				s.ServingsProtein, s.ServingsVeggies, test.carb, test.protein, test.veggies)
+		}
+		if s.PrepTimeMinutes != test.prep || s.TotalTimeMinutes != test.total ||
+			s.CriticalPathMinutes != test.critical {
+			t.Errorf("%d: minutes = %v %v %v, want %v %v %v", i, s.PrepTimeMinutes,
+				s.TotalTimeMinutes, s.CriticalPathMinutes, test.prep, test.total, test.critical)
+		}
+		if !reflect.DeepEqual(s.DishTypes, test.dishTypes) ||
+			!reflect.DeepEqual(s.MissingDishTypes, test.missing) {
+			t.Errorf("%d: dish types = %v missing %v, want %v missing %v", i,
+				s.DishTypes, s.MissingDishTypes, test.dishTypes, test.missing)
+		}
+		if len(s.Warnings) != test.warnings {
+			t.Errorf("%d: warnings = %v, want %d", i, s.Warnings, test.warnings)
+		}
+	}
+}
+
+func TestCheckServings(t *testing.T) {
+	tests := []struct {
+		servings, target, tolerance float32
+		want                        []string
+	}{
+		// no target
+		{5, 0, 0.5, []string{}},
+		{1, 1, 0.5, []string{}},
+		// within the tolerance, inclusive
+		{0.5, 1, 0.5, []string{}},
+		{1.5, 1, 0.5, []string{}},
+		{0.4, 1, 0.5, []string{"Too few servings of protein (0.4 of 1)"}},
+		{1.6, 1, 0.5, []string{"Too many servings of protein (1.6 of 1)"}},
+		// without tolerance anything but the target is off
+		{2.5, 2, 0, []string{"Too many servings of protein (2.5 of 2)"}},
+		{0, 2, 0, []string{"Too few servings of protein (0 of 2)"}},
+	}
+	for _, test := range tests {
+		s := &menuSummary{Warnings: make([]string, 0)}
+		s.checkServings("protein", test.servings, test.target, test.tolerance)
+		if !reflect.DeepEqual(s.Warnings, test.want) {
+			t.Errorf("checkServings(%v, %v, %v) = %v, want %v", test.servings,
+				test.target, test.tolerance, s.Warnings, test.want)
+		}
+	}
+}
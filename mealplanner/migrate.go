@@ -0,0 +1,186 @@
+package mealplanner
+
+// upgrades of libraries saved by older versions of the meal planner
+
+import (
+	"appengine"
+	"appengine/datastore"
+	"appengine/memcache"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// the version of the datastructures written by this code
+//  libraries with an older Version are upgraded in the background
+//  when they are opened, one version per task
+const libraryVersion = 6
+
+// how long a library stays locked for its upgrade, in case the tasks
+//  upgrading it stop without unlocking it
+const migrateLockExpiration = time.Hour
+
+// the upgrades of a library, migrations[v] upgrades a library of
+//  Version v to Version v+1
+//  they may run more than once, e.g. when a task is retried, so they
+//  must leave an upgraded library as it is
+var migrations = []func(c *context){
+	migrateMenuItems,
+	migratePrefixes,
+	migrateTagLists,
+	migratePairingTypes,
+	migrateKeywords,
+	migrateKeywords,
+}
+
+// the memcache key locking the upgrade of the library
+func migrateLockKey(c *context) string {
+	return "migrate/" + c.lid.Encode()
+}
+
+// start upgrading the data in the context's library to libraryVersion,
+//  unless it is already being upgraded
+func migrateLibrary(c *context) {
+	err := memcache.Add(c.c, &memcache.Item{Key: migrateLockKey(c),
+		Value: []byte{}, Expiration: migrateLockExpiration})
+	if err == memcache.ErrNotStored {
+		// the upgrade is already queued
+		return
+	}
+	queueMigration(c)
+}
+
+// queue the task for the next upgrade of the library
+func queueMigration(c *context) {
+	c.workQueue().add(c, "/task/migrate", url.Values{"library": {c.lid.Encode()}})
+}
+
+// handler for tasks to upgrade the library one Version, queues the
+//  next task until the library is at libraryVersion
+func migrateTaskHandler(c *context) {
+	version := c.l.Version
+	if version < libraryVersion {
+		migrations[version](c)
+		// record the upgrade, unless another task got there first
+		l := &Library{}
+		err := datastore.RunInTransaction(c.c, func(tc appengine.Context) error {
+			if err := datastore.Get(tc, c.lid, l); err != nil {
+				return err
+			}
+			if l.Version == version {
+				l.Version = version + 1
+				_, err := datastore.Put(tc, c.lid, l)
+				return err
+			}
+			return nil
+		}, nil)
+		check(err)
+		c.l = l
+		memcache.Gob.Set(c.c, &memcache.Item{Key: c.lid.Encode(), Object: c.l})
+	}
+	if c.l.Version < libraryVersion {
+		queueMigration(c)
+	} else {
+		memcache.Delete(c.c, migrateLockKey(c))
+	}
+}
+
+// version 1: move the list of dish keys on each menu into MenuItems
+func migrateMenuItems(c *context) {
+	menus := make([]Menu, 0, 100)
+	keys, err := c.NewQuery("Menu").GetAll(c.c, &menus)
+	check(err)
+	putItems := make([]interface{}, 0, len(menus))
+	putKeys := make([]*datastore.Key, 0, len(menus))
+	dirtyCacheEntries := make([]string, 0, len(menus)+2)
+	for i, _ := range menus {
+		if menus[i].migrateDishes() {
+			putItems = append(putItems, &menus[i])
+			putKeys = append(putKeys, keys[i])
+			dirtyCacheEntries = append(dirtyCacheEntries, c.lid.Encode()+"/menu/"+keys[i].Encode())
+		}
+	}
+	if len(putKeys) > 0 {
+		putMulti(c.c, putKeys, putItems)
+		// flush the menus from the cache
+		dirtyCacheEntries = append(dirtyCacheEntries, c.lid.Encode()+"/menu", c.lid.Encode()+"/menu/")
+		memcache.DeleteMulti(c.c, dirtyCacheEntries)
+	}
+}
+
+// version 2: add the prefixes used to complete the names and tags
+//  of dishes and ingredients, indexing an item updates its prefixes
+func migratePrefixes(c *context) {
+	for _, kind := range []string{"Dish", "Ingredient"} {
+		keys, err := c.NewQuery(kind).KeysOnly().GetAll(c.c, nil)
+		check(err)
+		queueIndex(c, keys...)
+	}
+}
+
+// version 3: move the Tags and Keyword children of dishes, ingredients
+//  and menus into the Tags of the items, the keywords are rebuilt in
+//  the background
+func migrateTagLists(c *context) {
+	// gather the tags of each item, by the encoded key of the item
+	tags := make(map[string][]string)
+	words := make([]Word, 0, 500)
+	wordKeys, err := c.NewQuery("Tags").GetAll(c.c, &words)
+	check(err)
+	for i, _ := range words {
+		parent := wordKeys[i].Parent().Encode()
+		if !hasTag(tags[parent], words[i].Word) {
+			tags[parent] = append(tags[parent], words[i].Word)
+		}
+	}
+	putItems := make([]interface{}, 0, 100)
+	putKeys := make([]*datastore.Key, 0, 100)
+	dirtyCacheEntries := make([]string, 0, 100)
+	for _, kind := range searchKinds {
+		path := c.lid.Encode() + "/" + strings.ToLower(kind)
+		dirtyCacheEntries = append(dirtyCacheEntries, path, path+"/")
+		iter := c.NewQuery(kind).Run(c.c)
+		item := newTaggedItem(kind)
+		for key, err := iter.Next(item); err != datastore.Done; key, err = iter.Next(item) {
+			check(err)
+			// keep tags moved by an earlier run, the task may be retried
+			//  after some items were stored
+			merged := item.tags()
+			for _, tag := range tags[key.Encode()] {
+				if !hasTag(merged, tag) {
+					merged = append(merged, tag)
+				}
+			}
+			item.setTags(merged)
+			item.setIndexStatus(indexPending)
+			putItems = append(putItems, item)
+			putKeys = append(putKeys, key)
+			itemPath := path + "/" + key.Encode()
+			dirtyCacheEntries = append(dirtyCacheEntries, itemPath,
+				itemPath+"/tags/", itemPath+"/keywords/")
+			item = newTaggedItem(kind)
+		}
+	}
+	putMulti(c.c, putKeys, putItems)
+	// remove the old children, only once the items have their tags
+	oldKeys := wordKeys
+	keywordKeys, err := c.NewQuery("Keyword").KeysOnly().GetAll(c.c, nil)
+	check(err)
+	oldKeys = append(oldKeys, keywordKeys...)
+	deleteMulti(c.c, oldKeys)
+	memcache.DeleteMulti(c.c, dirtyCacheEntries)
+	queueIndex(c, putKeys...)
+}
+
+// version 4: store the default pairing types, they used to be stored
+//  when the types were first listed
+func migratePairingTypes(c *context) {
+	seedPairingTypes(c)
+}
+
+// version 5: rebuild the keywords, english keywords are now stemmed
+// version 6: rebuild them again, combining marks of every script are
+//  now dropped when folding
+func migrateKeywords(c *context) {
+	reindexLibrary(c)
+}
@@ -0,0 +1,252 @@
+package mealplanner
+
+// parser for the search query language
+//  terms next to each other must all match, e.g. chicken rice
+//  OR between terms allows either to match, e.g. chicken OR tofu
+//  NOT or - before a term excludes matches, e.g. -spicy
+//  quotes match a phrase, e.g. "fried rice"
+//  parentheses group terms, e.g. (chicken OR tofu) rice
+//  tag: matches a tag instead of keywords, e.g. tag:weeknight,
+//  and its children, e.g. tag:cuisine/italian matches cuisine/italian/sicilian
+
+import (
+	"strings"
+	"unicode"
+)
+
+// kinds of nodes in a parsed query
+const (
+	// all children must match
+	queryAnd = iota
+	// any child may match
+	queryOr
+	// the only child must not match
+	queryNot
+	// a keyword matches
+	queryWord
+	// the words of a phrase match in order
+	queryPhrase
+	// a tag matches
+	queryTag
+)
+
+// fields that can prefix a term, e.g. tag:weeknight
+var queryFields = map[string]int{
+	"tag": queryTag,
+}
+
+// node of a parsed query
+type queryNode struct {
+	// one of the query* constants
+	kind int
+	// the text of a term
+	text string
+	// the operands of and, or and not
+	children []*queryNode
+}
+
+// kinds of tokens in a query
+const (
+	tokenTerm = iota
+	tokenOpen
+	tokenClose
+	tokenAnd
+	tokenOr
+	tokenNot
+)
+
+// token read from a query
+type queryToken struct {
+	// one of the token* constants
+	kind int
+	// the field of a term, "" for a keyword
+	field string
+	// the text of a term
+	text string
+	// true if the term was quoted
+	quoted bool
+}
+
+// parse the query text, panics with ErrBadQuery if it is malformed
+//  returns nil for an empty query
+func parseQuery(text string) *queryNode {
+	parser := &queryParser{tokens: tokenizeQuery(text)}
+	if len(parser.tokens) == 0 {
+		return nil
+	}
+	node := parser.parseOr()
+	if parser.pos < len(parser.tokens) {
+		// left over tokens means an unmatched ')'
+		check(ErrBadQuery)
+	}
+	return node
+}
+
+// combine the nodes so that all of them must match, skipping nil nodes
+//  returns nil if there are no nodes
+func andQueries(nodes ...*queryNode) *queryNode {
+	children := make([]*queryNode, 0, len(nodes))
+	for _, node := range nodes {
+		if node != nil {
+			children = append(children, node)
+		}
+	}
+	switch len(children) {
+	case 0:
+		return nil
+	case 1:
+		return children[0]
+	}
+	return &queryNode{kind: queryAnd, children: children}
+}
+
+// break the query text into tokens
+func tokenizeQuery(text string) []queryToken {
+	tokens := make([]queryToken, 0, 10)
+	runes := []rune(text)
+	pos := 0
+	// read a quoted string starting after the opening quote
+	readQuoted := func() string {
+		start := pos
+		for pos < len(runes) && runes[pos] != '"' {
+			pos++
+		}
+		quoted := string(runes[start:pos])
+		// skip the closing quote
+		pos++
+		return quoted
+	}
+	for pos < len(runes) {
+		r := runes[pos]
+		switch {
+		case unicode.IsSpace(r):
+			pos++
+		case r == '(':
+			tokens = append(tokens, queryToken{kind: tokenOpen})
+			pos++
+		case r == ')':
+			tokens = append(tokens, queryToken{kind: tokenClose})
+			pos++
+		case r == '-' && pos+1 < len(runes) && !unicode.IsSpace(runes[pos+1]):
+			tokens = append(tokens, queryToken{kind: tokenNot})
+			pos++
+		case r == '"':
+			pos++
+			tokens = append(tokens, queryToken{kind: tokenTerm, text: readQuoted(), quoted: true})
+		default:
+			start := pos
+			for pos < len(runes) && !unicode.IsSpace(runes[pos]) &&
+				runes[pos] != '(' && runes[pos] != ')' && runes[pos] != '"' {
+				pos++
+			}
+			word := string(runes[start:pos])
+			switch word {
+			case "AND":
+				tokens = append(tokens, queryToken{kind: tokenAnd})
+				continue
+			case "OR":
+				tokens = append(tokens, queryToken{kind: tokenOr})
+				continue
+			case "NOT":
+				tokens = append(tokens, queryToken{kind: tokenNot})
+				continue
+			}
+			token := queryToken{kind: tokenTerm, text: word}
+			// check for a field prefix
+			if colon := strings.Index(word, ":"); colon > 0 {
+				field := strings.ToLower(word[:colon])
+				if _, found := queryFields[field]; found {
+					token.field = field
+					token.text = word[colon+1:]
+					// the value may be quoted, e.g. tag:"quick meals"
+					if len(token.text) == 0 && pos < len(runes) && runes[pos] == '"' {
+						pos++
+						token.text = readQuoted()
+						token.quoted = true
+					}
+				}
+			}
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// recursive descent parser for the query tokens
+type queryParser struct {
+	tokens []queryToken
+	// index of the next token to be parsed
+	pos int
+}
+
+// return the kind of the next token, or -1 at the end
+func (self *queryParser) peek() int {
+	if self.pos >= len(self.tokens) {
+		return -1
+	}
+	return self.tokens[self.pos].kind
+}
+
+// parse terms separated by OR
+func (self *queryParser) parseOr() *queryNode {
+	children := []*queryNode{self.parseAnd()}
+	for self.peek() == tokenOr {
+		self.pos++
+		children = append(children, self.parseAnd())
+	}
+	if len(children) == 1 {
+		return children[0]
+	}
+	return &queryNode{kind: queryOr, children: children}
+}
+
+// parse terms that must all match, with or without AND between them
+func (self *queryParser) parseAnd() *queryNode {
+	children := make([]*queryNode, 0, 4)
+	for {
+		kind := self.peek()
+		if kind == tokenAnd {
+			self.pos++
+			continue
+		}
+		if kind != tokenTerm && kind != tokenNot && kind != tokenOpen {
+			break
+		}
+		children = append(children, self.parseUnary())
+	}
+	switch len(children) {
+	case 0:
+		// e.g. "()" or "chicken OR"
+		check(ErrBadQuery)
+	case 1:
+		return children[0]
+	}
+	return &queryNode{kind: queryAnd, children: children}
+}
+
+// parse a term, a negated term, or a group in parentheses
+func (self *queryParser) parseUnary() *queryNode {
+	token := self.tokens[self.pos]
+	self.pos++
+	switch token.kind {
+	case tokenNot:
+		if self.peek() != tokenTerm && self.peek() != tokenOpen && self.peek() != tokenNot {
+			check(ErrBadQuery)
+		}
+		return &queryNode{kind: queryNot, children: []*queryNode{self.parseUnary()}}
+	case tokenOpen:
+		node := self.parseOr()
+		if self.peek() != tokenClose {
+			check(ErrBadQuery)
+		}
+		self.pos++
+		return node
+	}
+	if len(token.field) > 0 {
+		return &queryNode{kind: queryFields[token.field], text: token.text}
+	}
+	if token.quoted {
+		return &queryNode{kind: queryPhrase, text: token.text}
+	}
+	return &queryNode{kind: queryWord, text: token.text}
+}
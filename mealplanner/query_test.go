@@ -0,0 +1,91 @@
+package mealplanner
+
+import (
+	"strings"
+	"testing"
+)
+
+// write the parsed query as an s-expression, e.g. (and word:a (not tag:b))
+func queryString(node *queryNode) string {
+	if node == nil {
+		return "nil"
+	}
+	switch node.kind {
+	case queryWord:
+		return "word:" + node.text
+	case queryPhrase:
+		return "phrase:" + node.text
+	case queryTag:
+		return "tag:" + node.text
+	}
+	names := map[int]string{queryAnd: "and", queryOr: "or", queryNot: "not"}
+	parts := []string{names[node.kind]}
+	for _, child := range node.children {
+		parts = append(parts, queryString(child))
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", "nil"},
+		{"   ", "nil"},
+		{"chicken", "word:chicken"},
+		{"chicken rice", "(and word:chicken word:rice)"},
+		{"chicken AND rice", "(and word:chicken word:rice)"},
+		{"chicken OR tofu", "(or word:chicken word:tofu)"},
+		{"chicken OR tofu rice", "(or word:chicken (and word:tofu word:rice))"},
+		{"(chicken OR tofu) rice", "(and (or word:chicken word:tofu) word:rice)"},
+		{"-spicy", "(not word:spicy)"},
+		{"NOT spicy", "(not word:spicy)"},
+		{"rice -(spicy OR hot)", "(and word:rice (not (or word:spicy word:hot)))"},
+		{"a - b", "(and word:a word:- word:b)"},
+		{`"fried rice"`, "phrase:fried rice"},
+		{"tag:weeknight", "tag:weeknight"},
+		{"TAG:weeknight", "tag:weeknight"},
+		{`tag:"quick meals"`, "tag:quick meals"},
+		{"tag:cuisine/italian -tag:spicy", "(and tag:cuisine/italian (not tag:spicy))"},
+		{"time:10", "word:time:10"},
+	}
+	for _, test := range tests {
+		got := queryString(parseQuery(test.query))
+		if got != test.want {
+			t.Errorf("parseQuery(%q) = %s, want %s", test.query, got, test.want)
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, query := range []string{"()", "chicken OR", "(chicken", "chicken)", "NOT", "OR rice"} {
+		func() {
+			defer func() {
+				if err := recover(); err != ErrBadQuery {
+					t.Errorf("parseQuery(%q) panicked with %v, want ErrBadQuery", query, err)
+				}
+			}()
+			parseQuery(query)
+		}()
+	}
+}
+
+func TestAndQueries(t *testing.T) {
+	word := &queryNode{kind: queryWord, text: "rice"}
+	tag := &queryNode{kind: queryTag, text: "quick"}
+	tests := []struct {
+		nodes []*queryNode
+		want  string
+	}{
+		{nil, "nil"},
+		{[]*queryNode{nil, nil}, "nil"},
+		{[]*queryNode{nil, word}, "word:rice"},
+		{[]*queryNode{word, nil, tag}, "(and word:rice tag:quick)"},
+	}
+	for _, test := range tests {
+		if got := queryString(andQueries(test.nodes...)); got != test.want {
+			t.Errorf("andQueries(%v) = %s, want %s", test.nodes, got, test.want)
+		}
+	}
+}
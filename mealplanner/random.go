@@ -0,0 +1,137 @@
+package mealplanner
+
+// picking a dish at random, for when the cook can't decide what to make
+
+import (
+	"math/rand"
+	"time"
+)
+
+// weight of a dish that hasn't been rated, between the ratings 1-5
+const unratedWeight = 3
+
+// dishes cooked at least this many days ago are as likely to be picked
+//  as dishes that were never cooked
+const randomRecencyDays = 60
+
+// the weight of a dish cooked today, relative to one never cooked,
+//  so that it may still be picked
+const cookedTodayWeight = 0.1
+
+// parameters to pick a random dish, client "POST"s as JSON
+type randomParams struct {
+	// the dishes to choose from, the same as for a search, e.g. DishType,
+	//  Tags and MaxTotalMinutes
+	searchParams
+	// diets the dish must be on, with or without dietTag, e.g. low-carb
+	//  or diet/low-carb, see dietTags
+	Diet []string
+	// false to pick any dish with the same chance, true to favor dishes
+	//  with a better Rating that haven't been cooked lately
+	Weighted bool
+	// ids of dishes not to pick, e.g. those suggested recently
+	Exclude []string
+}
+
+// handler to pick a random dish, /dish/random
+//  GET picks from every dish, POST picks from those matching the
+//  randomParams sent as JSON
+// sends the Dish, or null if no dish matches
+func randomDishHandler(c *context) {
+	rp := randomParams{}
+	switch c.r.Method {
+	case "GET":
+	case "POST":
+		readJSON(c.r, &rp)
+	default:
+		check(ErrUnsupported)
+	}
+	if dish := pickRandomDish(c, &rp); dish != nil {
+		c.sendJSONNoCache(dish)
+	} else {
+		c.sendJSONNoCache(nil)
+	}
+}
+
+// the weight of the dish when picking with Weighted, see randomParams
+func randomWeight(dish *Dish, now time.Time) float64 {
+	weight := float64(unratedWeight)
+	if dish.Rating > 0 {
+		weight = float64(dish.Rating)
+	}
+	if dish.CookedCount > 0 {
+		days := now.Sub(dish.LastCooked).Hours() / 24
+		recency := days / randomRecencyDays
+		if recency > 1 {
+			recency = 1
+		}
+		if recency < cookedTodayWeight {
+			recency = cookedTodayWeight
+		}
+		weight *= recency
+	}
+	return weight
+}
+
+// pick a dish matching the parameters, returns nil if none match
+func pickRandomDish(c *context, rp *randomParams) *Dish {
+	sp := rp.searchParams
+	sp.Have = nil
+	// the dish must have one of the tags of each diet
+	parts := []*queryNode{sp.query()}
+	for _, diet := range rp.Diet {
+		anyTag := &queryNode{kind: queryOr}
+		for _, tag := range dietTags(diet) {
+			anyTag.children = append(anyTag.children, &queryNode{kind: queryTag, text: tag})
+		}
+		parts = append(parts, anyTag)
+	}
+	query := andQueries(parts...)
+	var results searchResults
+	if query != nil || sp.filtered() {
+		results = runQuery(c, &sp, query)
+	}
+	excluded := make(map[string]bool)
+	for _, id := range rp.Exclude {
+		excluded[id] = true
+	}
+	// gather the dishes to choose from
+	dishes := make([]Dish, 0, 100)
+	keys, err := c.NewQuery("Dish").GetAll(c.c, &dishes)
+	check(err)
+	candidates := make([]*Dish, 0, len(dishes))
+	for i, _ := range dishes {
+		id := keys[i].Encode()
+		if _, found := results["Dish"][id]; results != nil && !found {
+			continue
+		}
+		if excluded[id] {
+			continue
+		}
+		dishes[i].SetID(id)
+		candidates = append(candidates, &dishes[i])
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	if !rp.Weighted {
+		return candidates[random.Intn(len(candidates))]
+	}
+	now := time.Now()
+	weights := make([]float64, len(candidates))
+	total := 0.0
+	for i, dish := range candidates {
+		weights[i] = randomWeight(dish, now)
+		total += weights[i]
+	}
+	pick := random.Float64() * total
+	for i, weight := range weights {
+		if pick < weight {
+			return candidates[i]
+		}
+		pick -= weight
+	}
+	// rounding may leave the pick just past the end
+	return candidates[len(candidates)-1]
+}
@@ -0,0 +1,38 @@
+package mealplanner
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRandomWeight(t *testing.T) {
+	now := time.Date(2013, 5, 1, 18, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) time.Time {
+		return now.AddDate(0, 0, -days)
+	}
+	tests := []struct {
+		dish Dish
+		want float64
+	}{
+		// never cooked, by rating
+		{Dish{}, unratedWeight},
+		{Dish{Rating: 1}, 1},
+		{Dish{Rating: 5}, 5},
+		// cooked long ago counts as never cooked
+		{Dish{Rating: 4, CookedCount: 2, LastCooked: daysAgo(randomRecencyDays)}, 4},
+		{Dish{Rating: 4, CookedCount: 2, LastCooked: daysAgo(365)}, 4},
+		// cooked lately is less likely
+		{Dish{Rating: 4, CookedCount: 1, LastCooked: daysAgo(randomRecencyDays / 2)}, 2},
+		{Dish{CookedCount: 1, LastCooked: daysAgo(randomRecencyDays / 4)}, 0.75},
+		// cooked today can still be picked
+		{Dish{Rating: 5, CookedCount: 1, LastCooked: now}, 5 * cookedTodayWeight},
+		{Dish{Rating: 5, CookedCount: 1, LastCooked: now.Add(time.Hour)}, 5 * cookedTodayWeight},
+	}
+	for _, test := range tests {
+		got := randomWeight(&test.dish, now)
+		if got < test.want-1e-9 || got > test.want+1e-9 {
+			t.Errorf("randomWeight(Rating %d, CookedCount %d, LastCooked %v) = %v, want %v",
+				test.dish.Rating, test.dish.CookedCount, test.dish.LastCooked, got, test.want)
+		}
+	}
+}
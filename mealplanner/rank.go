@@ -0,0 +1,572 @@
+package mealplanner
+
+// ranking and paging of search results
+
+import (
+	"appengine/datastore"
+	"appengine/memcache"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// an item found by a search, sent to the client as JSON
+type searchResult struct {
+	// kind of the item, Dish, Ingredient or Menu
+	Kind string
+	// Id of the item
+	Id string
+	// Name of the item
+	Name string
+	// DishType of a dish or Category of an ingredient
+	Type string
+	// Rating of a dish
+	Rating int
+	// short piece of text showing why the item matched
+	Snippet string
+	// the words of the search found in each field of the item
+	Highlights []*highlight
+	// how well the item matched, higher is better
+	Score float32
+	// Id of the library holding the item
+	Library string
+	// Name of the library holding the item
+	LibraryName string
+	// true if the user can only view the item
+	ReadOnly bool
+}
+
+// a page of ranked search results, sent to the client as JSON
+type searchPage struct {
+	// the results on this page, best first
+	Results []*searchResult
+	// how many items matched in all
+	Total int
+	// pass as the Cursor of the searchParams to get the next page,
+	//  empty if this is the last page
+	Cursor string
+	// queries with misspelled words corrected, set when nothing matched
+	DidYouMean []string
+	// true if nothing matched the search, and the results are for
+	//  the first of the DidYouMean queries instead
+	Corrected bool
+}
+
+// the words of a search found in a field of an item, sent to the
+//  client as JSON
+type highlight struct {
+	// the field the words were found in, e.g. Name, Source or Text
+	Field string
+	// the text of the field around the first of the words
+	Snippet string
+	// start and end of each word found in the Snippet, counted in
+	//  characters, not bytes
+	Offsets [][2]int
+}
+
+// a field of an item to score and highlight
+type fieldText struct {
+	field, text string
+}
+
+// how much a term matching the name of an item adds to its score,
+//  a term matching elsewhere adds 1
+const searchNameWeight = 3
+
+// the number of results on a page if the client doesn't give a Limit
+const defaultSearchLimit = 50
+
+// the number of characters of text around a match in a snippet
+const snippetLength = 100
+
+// how long the ranking of a search is kept for its later pages
+const searchCacheExpiration = 10 * time.Minute
+
+// an item found by a search, as kept in the cache between pages
+type rankedItem struct {
+	Kind        string
+	Id          string
+	Score       float32
+	Library     string
+	LibraryName string
+	ReadOnly    bool
+}
+
+// the ranking of a search, cached so that later pages only load the
+//  items on the page
+type rankedSearch struct {
+	Items      []rankedItem
+	DidYouMean []string
+	Corrected  bool
+}
+
+// sort search results with the highest score first
+type resultsByScore []*searchResult
+
+func (self resultsByScore) Len() int {
+	return len(self)
+}
+func (self resultsByScore) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+func (self resultsByScore) Less(i, j int) bool {
+	if self[i].Score != self[j].Score {
+		return self[i].Score > self[j].Score
+	}
+	if self[i].Name != self[j].Name {
+		return self[i].Name < self[j].Name
+	}
+	return self[i].Id < self[j].Id
+}
+
+// run the search and return the requested page of ranked results
+//  the first page runs the search and caches the ranking, later pages
+//  use the cached ranking and only load the items on the page
+func searchPageFor(c *context, sp *searchParams) *searchPage {
+	offset := decodeSearchCursor(sp.Cursor)
+	limit := sp.Limit
+	if limit == 0 {
+		limit = defaultSearchLimit
+	}
+	if limit < 0 {
+		check(ErrBadQuery)
+	}
+	cacheKey := searchCacheKey(c, sp)
+	ranking := &rankedSearch{}
+	// the results of the search by id, if it was run for this page
+	var loaded map[string]*searchResult
+	if _, err := memcache.Gob.Get(c.c, cacheKey, ranking); offset == 0 || err != nil {
+		ranking, loaded = rankSearch(c, sp)
+		memcache.Gob.Set(c.c, &memcache.Item{Key: cacheKey, Object: ranking,
+			Expiration: searchCacheExpiration})
+	}
+	page := &searchPage{
+		Total:      len(ranking.Items),
+		DidYouMean: ranking.DidYouMean,
+		Corrected:  ranking.Corrected,
+	}
+	if offset > len(ranking.Items) {
+		offset = len(ranking.Items)
+	}
+	end := offset + limit
+	if end < len(ranking.Items) {
+		page.Cursor = encodeSearchCursor(end)
+	} else {
+		end = len(ranking.Items)
+	}
+	items := ranking.Items[offset:end]
+	if loaded == nil {
+		loaded = loadRankedItems(c, sp, items)
+	}
+	page.Results = make([]*searchResult, 0, len(items))
+	for _, item := range items {
+		// items deleted since the search was run are left out
+		if result, found := loaded[item.Id]; found {
+			page.Results = append(page.Results, result)
+		}
+	}
+	return page
+}
+
+// the memcache key of the ranking of a search, the same for every page
+func searchCacheKey(c *context, sp *searchParams) string {
+	params := *sp
+	params.Cursor = ""
+	params.Limit = 0
+	data, err := json.Marshal(&params)
+	check(err)
+	return fmt.Sprintf("search%v%v%x", c.lid.Encode(), c.getUid(), sha1.Sum(data))
+}
+
+// run the search, correcting misspelled words if nothing matches,
+//  returns the ranking and the results by id
+func rankSearch(c *context, sp *searchParams) (*rankedSearch, map[string]*searchResult) {
+	ranking := &rankedSearch{}
+	ranked := searchLibraries(c, sp)
+	if len(ranked) == 0 {
+		// maybe a word was misspelled
+		ranking.DidYouMean = correctQuery(c, sp)
+		if len(ranking.DidYouMean) > 0 {
+			corrected := *sp
+			corrected.Query = ranking.DidYouMean[0]
+			// the corrected query includes the tags and words
+			corrected.Tags = nil
+			corrected.Word = ""
+			ranked = searchLibraries(c, &corrected)
+			ranking.Corrected = len(ranked) > 0
+		}
+	}
+	ranking.Items = make([]rankedItem, len(ranked))
+	loaded := make(map[string]*searchResult)
+	for i, result := range ranked {
+		ranking.Items[i] = rankedItem{result.Kind, result.Id, result.Score,
+			result.Library, result.LibraryName, result.ReadOnly}
+		loaded[result.Id] = result
+	}
+	return ranking, loaded
+}
+
+// load the items of a page of a cached ranking, returns the results
+//  by id, keeping the scores of the ranking
+func loadRankedItems(c *context, sp *searchParams, items []rankedItem) map[string]*searchResult {
+	// group the items by library
+	byLibrary := make(map[string]searchResults)
+	for _, item := range items {
+		results, found := byLibrary[item.Library]
+		if !found {
+			results = make(searchResults)
+			byLibrary[item.Library] = results
+		}
+		results.add(item.Kind, item.Id, 1)
+	}
+	libs := map[string]*Library{c.lid.Encode(): c.l}
+	if sp.AllLibraries {
+		libraries, others := userLibraries(c)
+		for i, library := range libraries {
+			libs[library.Id.Encode()] = others[i]
+		}
+	}
+	loaded := make(map[string]*searchResult)
+	for id, results := range byLibrary {
+		l, found := libs[id]
+		if !found {
+			// the user can't see the library any more
+			continue
+		}
+		lid, err := datastore.DecodeKey(id)
+		check(err)
+		libContext := *c
+		libContext.lid = lid
+		libContext.l = l
+		for _, result := range rankResults(&libContext, sp, results) {
+			loaded[result.Id] = result
+		}
+	}
+	for _, item := range items {
+		if result, found := loaded[item.Id]; found {
+			result.Score = item.Score
+			result.Library = item.Library
+			result.LibraryName = item.LibraryName
+			result.ReadOnly = item.ReadOnly
+		}
+	}
+	return loaded
+}
+
+// run the search in the current library, or in every library the
+//  user can access if AllLibraries is set, and rank the results
+func searchLibraries(c *context, sp *searchParams) []*searchResult {
+	if !sp.AllLibraries {
+		ranked := rankResults(c, sp, runSearch(c, sp))
+		name := c.l.Name
+		if len(name) == 0 {
+			name = c.l.OwnerId
+		}
+		annotateResults(ranked, c.lid, name, c.readOnly)
+		return ranked
+	}
+	ranked := make([]*searchResult, 0, 100)
+	libraries, libs := userLibraries(c)
+	for i, library := range libraries {
+		// search using a context for the other library
+		libContext := *c
+		libContext.lid = library.Id
+		libContext.l = libs[i]
+		libContext.readOnly = library.ReadOnly
+		results := rankResults(&libContext, sp, runSearch(&libContext, sp))
+		annotateResults(results, library.Id, library.Name, library.ReadOnly)
+		ranked = append(ranked, results...)
+	}
+	sort.Sort(resultsByScore(ranked))
+	return ranked
+}
+
+// record the library the results were found in
+func annotateResults(results []*searchResult, lid *datastore.Key, name string, readOnly bool) {
+	id := lid.Encode()
+	for _, result := range results {
+		result.Library = id
+		result.LibraryName = name
+		result.ReadOnly = readOnly
+	}
+}
+
+// build the opaque cursor for a position in the results
+func encodeSearchCursor(offset int) string {
+	return base64.URLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+// get the position in the results from a cursor, 0 for no cursor
+func decodeSearchCursor(cursor string) int {
+	if len(cursor) == 0 {
+		return 0
+	}
+	data, err := base64.URLEncoding.DecodeString(cursor)
+	if err != nil {
+		check(ErrBadQuery)
+	}
+	offset, err := strconv.Atoi(string(data))
+	if err != nil || offset < 0 {
+		check(ErrBadQuery)
+	}
+	return offset
+}
+
+// look up the items found and order them by how well they match
+func rankResults(c *context, sp *searchParams, results searchResults) []*searchResult {
+	a := c.analyzer()
+	terms := make([]*queryNode, 0, 10)
+	if query := sp.query(); query != nil {
+		terms = query.positiveTerms(terms)
+	}
+	// the keywords of the terms, to find in the text of the items
+	keywords := make(map[string]bool)
+	for _, term := range terms {
+		addWords(a, term.text, keywords)
+	}
+	ranked := make([]*searchResult, 0, len(results["Dish"])+
+		len(results["Ingredient"])+len(results["Menu"]))
+	// add the result if the item was found, scoring the fields of the item
+	addResult := func(kind string, key *datastore.Key, name string, body ...fieldText) *searchResult {
+		id := key.Encode()
+		count, found := results[kind][id]
+		if !found {
+			return nil
+		}
+		result := &searchResult{
+			Kind:  kind,
+			Id:    id,
+			Name:  name,
+			Score: float32(count),
+		}
+		for _, term := range terms {
+			if term.matchesText(a, name) {
+				result.Score += searchNameWeight
+				continue
+			}
+			for _, field := range body {
+				if term.matchesText(a, field.text) {
+					result.Score++
+					break
+				}
+			}
+		}
+		// show where the words were found, the snippet is from the
+		//  first field after the name
+		if h := highlightText(a, "Name", name, keywords); h != nil {
+			result.Highlights = append(result.Highlights, h)
+		}
+		for _, field := range body {
+			if h := highlightText(a, field.field, field.text, keywords); h != nil {
+				result.Highlights = append(result.Highlights, h)
+				if len(result.Snippet) == 0 {
+					result.Snippet = h.Snippet
+				}
+			}
+		}
+		ranked = append(ranked, result)
+		return result
+	}
+	// load only the items found, and the dishes of the menus found
+	menuKeys := resultKeys(results["Menu"])
+	menus := make([]Menu, len(menuKeys))
+	menuErrs := getMulti(c.c, menuKeys, menus)
+	dishIds := make(map[string]uint)
+	for id, _ := range results["Dish"] {
+		dishIds[id] = 1
+	}
+	for i, _ := range menus {
+		if menuErrs[i] != nil {
+			continue
+		}
+		menus[i].migrateDishes()
+		for _, item := range menus[i].Items {
+			if item.Dish != nil {
+				dishIds[item.Dish.Encode()] = 1
+			}
+		}
+	}
+	dishNames := make(map[string]string)
+	if len(dishIds) > 0 {
+		keys := resultKeys(dishIds)
+		dishes := make([]Dish, len(keys))
+		errs := getMulti(c.c, keys, dishes)
+		for i, _ := range dishes {
+			if errs[i] == datastore.ErrNoSuchEntity {
+				continue
+			}
+			check(errs[i])
+			dish := &dishes[i]
+			dishNames[keys[i].Encode()] = dish.Name
+			result := addResult("Dish", keys[i], dish.Name,
+				fieldText{"Source", dish.Source}, fieldText{"Text", dish.Text})
+			if result == nil {
+				continue
+			}
+			result.Type = dish.DishType
+			result.Rating = dish.Rating
+			if len(result.Snippet) == 0 {
+				result.Snippet = leadingText(dish.Text)
+			}
+		}
+	}
+	if len(results["Ingredient"]) > 0 {
+		keys := resultKeys(results["Ingredient"])
+		ings := make([]Ingredient, len(keys))
+		errs := getMulti(c.c, keys, ings)
+		for i, _ := range ings {
+			if errs[i] == datastore.ErrNoSuchEntity {
+				continue
+			}
+			check(errs[i])
+			ing := &ings[i]
+			result := addResult("Ingredient", keys[i], ing.Name,
+				fieldText{"Category", ing.Category})
+			if result == nil {
+				continue
+			}
+			result.Type = ing.Category
+			if len(result.Snippet) == 0 {
+				result.Snippet = ing.Source
+			}
+		}
+	}
+	for i, _ := range menus {
+		if menuErrs[i] == datastore.ErrNoSuchEntity {
+			continue
+		}
+		check(menuErrs[i])
+		// the menu's dishes are matched as its body
+		names := make([]string, 0, len(menus[i].Items))
+		for _, item := range menus[i].Items {
+			if item.Dish == nil {
+				continue
+			}
+			if name, found := dishNames[item.Dish.Encode()]; found {
+				names = append(names, name)
+			}
+		}
+		dishes := strings.Join(names, ", ")
+		result := addResult("Menu", menuKeys[i], menus[i].Name,
+			fieldText{"Dishes", dishes})
+		if result != nil && len(result.Snippet) == 0 {
+			result.Snippet = leadingText(dishes)
+		}
+	}
+	sort.Sort(resultsByScore(ranked))
+	return ranked
+}
+
+// decode the ids of the results of a kind into keys
+func resultKeys(ids map[string]uint) []*datastore.Key {
+	keys := make([]*datastore.Key, 0, len(ids))
+	for id, _ := range ids {
+		key, err := datastore.DecodeKey(id)
+		check(err)
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// append the words and phrases of the query that items should have,
+//  that is those that aren't negated
+func (self *queryNode) positiveTerms(terms []*queryNode) []*queryNode {
+	switch self.kind {
+	case queryAnd, queryOr:
+		for _, child := range self.children {
+			terms = child.positiveTerms(terms)
+		}
+	case queryWord, queryPhrase:
+		terms = append(terms, self)
+	}
+	return terms
+}
+
+// check if the text has the word or phrase of the term
+func (self *queryNode) matchesText(a analyzer, text string) bool {
+	if len(text) == 0 {
+		return false
+	}
+	if self.kind == queryPhrase {
+		return containsPhrase(a, text, a.tokenize(self.text))
+	}
+	wanted := make(map[string]bool)
+	addWords(a, self.text, wanted)
+	found := make(map[string]bool)
+	addWords(a, text, found)
+	for word, _ := range wanted {
+		if found[word] {
+			return true
+		}
+	}
+	return false
+}
+
+// find the words with the keywords in the text of a field
+//  returns nil if none are found
+func highlightText(a analyzer, field, text string, keywords map[string]bool) *highlight {
+	found := make([]wordSpan, 0, 5)
+	for _, span := range a.spans(text) {
+		keyword := a.keyword(span.word)
+		if _, ok := keywords[keyword]; ok && len(keyword) > 0 {
+			found = append(found, span)
+		}
+	}
+	if len(found) == 0 {
+		return nil
+	}
+	// take about snippetLength bytes of text around the first word
+	start := found[0].start - snippetLength/2
+	if start <= 0 {
+		start = 0
+	}
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start++
+	}
+	end := start + snippetLength
+	if end < found[0].end {
+		end = found[0].end
+	}
+	if end >= len(text) {
+		end = len(text)
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end--
+	}
+	h := &highlight{Field: field, Snippet: text[start:end]}
+	prefix := 0
+	if start > 0 {
+		h.Snippet = "..." + h.Snippet
+		prefix = 3
+	}
+	if end < len(text) {
+		h.Snippet += "..."
+	}
+	for _, span := range found {
+		if span.start < start || span.end > end {
+			continue
+		}
+		wordStart := prefix + utf8.RuneCountInString(text[start:span.start])
+		wordEnd := wordStart + utf8.RuneCountInString(text[span.start:span.end])
+		h.Offsets = append(h.Offsets, [2]int{wordStart, wordEnd})
+	}
+	return h
+}
+
+// get the start of the text, cut to about snippetLength characters
+func leadingText(text string) string {
+	if len(text) <= snippetLength {
+		return text
+	}
+	end := snippetLength
+	for end > 0 && !utf8.RuneStart(text[end]) {
+		end--
+	}
+	return text[:end] + "..."
+}
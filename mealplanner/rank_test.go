@@ -0,0 +1,94 @@
+package mealplanner
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHighlightText(t *testing.T) {
+	a := englishAnalyzer{}
+	long := strings.Repeat("stir well ", 10) + "add the roasted garlic " +
+		strings.Repeat("and serve ", 10)
+	tests := []struct {
+		text     string
+		keywords []string
+		want     *highlight
+	}{
+		{"Fried rice", []string{"chicken"}, nil},
+		{"", []string{"rice"}, nil},
+		// stop words aren't matched
+		{"Fish and chips", []string{""}, nil},
+		{"Fried rice", []string{"rice"},
+			&highlight{"Text", "Fried rice", [][2]int{{6, 10}}}},
+		// matched by the keyword, so any form of the word is found
+		{"Roasted peppers, roasting pan", []string{"roast"},
+			&highlight{"Text", "Roasted peppers, roasting pan", [][2]int{{0, 7}, {17, 25}}}},
+		// offsets count characters, not bytes
+		{"Crème brûlée with jalapeño", []string{"jalapeno"},
+			&highlight{"Text", "Crème brûlée with jalapeño", [][2]int{{18, 26}}}},
+		// long text is cut around the first word found
+		{long, []string{"garlic"},
+			&highlight{"Text", "..." + long[66:166] + "...", [][2]int{{53, 59}}}},
+	}
+	for _, test := range tests {
+		keywords := make(map[string]bool)
+		for _, keyword := range test.keywords {
+			keywords[keyword] = true
+		}
+		got := highlightText(a, "Text", test.text, keywords)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("highlightText(%q, %v) = %v, want %v", test.text, test.keywords, got, test.want)
+		}
+	}
+}
+
+func TestLeadingText(t *testing.T) {
+	short := "Simmer the beans"
+	long := strings.Repeat("a", snippetLength-1) + "éclair"
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"", ""},
+		{short, short},
+		{strings.Repeat("b", snippetLength), strings.Repeat("b", snippetLength)},
+		// cut before the letter split by the limit
+		{long, strings.Repeat("a", snippetLength-1) + "..."},
+	}
+	for _, test := range tests {
+		if got := leadingText(test.text); got != test.want {
+			t.Errorf("leadingText(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestSearchCursor(t *testing.T) {
+	for _, offset := range []int{0, 1, 50, 200, 123456} {
+		cursor := encodeSearchCursor(offset)
+		if got := decodeSearchCursor(cursor); got != offset {
+			t.Errorf("decodeSearchCursor(encodeSearchCursor(%d)) = %d", offset, got)
+		}
+	}
+	// no cursor is the first page
+	if got := decodeSearchCursor(""); got != 0 {
+		t.Errorf("decodeSearchCursor(\"\") = %d, want 0", got)
+	}
+	malformed := []string{
+		"not base64!",
+		// base64 of "ten"
+		"dGVu",
+		// base64 of "-5"
+		"LTU=",
+	}
+	for _, cursor := range malformed {
+		func() {
+			defer func() {
+				if err := recover(); err != ErrBadQuery {
+					t.Errorf("decodeSearchCursor(%q) panicked with %v, want ErrBadQuery", cursor, err)
+				}
+			}()
+			decodeSearchCursor(cursor)
+		}()
+	}
+}
@@ -0,0 +1,171 @@
+package mealplanner
+
+// rebuilding the keyword index of a library, needed when the analyzer
+//  for its language changes, and checking the index for drift from
+//  the items it indexes
+
+import (
+	"appengine/datastore"
+	"appengine/memcache"
+	"appengine/user"
+	"sort"
+)
+
+// counts of the items reindexed, sent to the client as JSON
+type reindexReport struct {
+	// the language of the analyzer used
+	Language string
+	// how many of each kind of item were reindexed
+	Dishes      int
+	Ingredients int
+	Menus       int
+}
+
+// the keywords of an item that don't match what it should have,
+//  sent to the client as JSON
+type indexDrift struct {
+	// kind of the item, Dish, Ingredient or Menu
+	Kind string
+	// Id of the item
+	Id string
+	// Name of the item
+	Name string
+	// true if the item has changed and its keywords are waiting to
+	//  be updated, so the drift is expected
+	Pending bool
+	// keywords the item should have, but doesn't
+	Missing []string
+	// keywords the item has, but shouldn't
+	Extra []string
+}
+
+// result of checking the keyword index, sent to the client as JSON
+type driftReport struct {
+	// the language of the analyzer used
+	Language string
+	// how many of each kind of item were checked
+	Dishes      int
+	Ingredients int
+	Menus       int
+	// the items with keywords that don't match
+	Drifted []*indexDrift
+}
+
+// handler for administrators to check or rebuild the keywords of the
+//  current library
+//  GET /reindex reports the items whose keywords have drifted
+//  POST /reindex rebuilds them all, the optional "language" parameter
+//  changes the Language of the library first
+func reindexHandler(c *context) {
+	if !user.IsAdmin(c.c) {
+		check(ErrPermissionDenied)
+	}
+	switch c.r.Method {
+	case "GET":
+		c.sendJSONNoCache(checkIndex(c))
+	case "POST":
+		if language := c.r.FormValue("language"); len(language) > 0 {
+			if _, found := analyzers[language]; !found {
+				check(ErrUnsupported)
+			}
+			c.l.Language = language
+			_, err := datastore.Put(c.c, c.lid, c.l)
+			check(err)
+			memcache.Gob.Set(c.c, &memcache.Item{Key: c.lid.Encode(), Object: c.l})
+		}
+		c.sendJSONNoCache(reindexLibrary(c))
+	default:
+		check(ErrUnsupported)
+	}
+}
+
+// rebuild the keywords of every dish, ingredient and menu in the library
+//  the work is done by background tasks
+func reindexLibrary(c *context) *reindexReport {
+	report := &reindexReport{Language: c.analyzerLanguage()}
+	all := make([]*datastore.Key, 0, 100)
+	for _, kind := range searchKinds {
+		keys, err := c.NewQuery(kind).KeysOnly().GetAll(c.c, nil)
+		check(err)
+		switch kind {
+		case "Dish":
+			report.Dishes = len(keys)
+		case "Ingredient":
+			report.Ingredients = len(keys)
+		case "Menu":
+			report.Menus = len(keys)
+		}
+		all = append(all, keys...)
+	}
+	queueIndex(c, all...)
+	return report
+}
+
+// compare the keywords of every dish, ingredient and menu in the library
+//  with what the analyzer gives for them now
+func checkIndex(c *context) *driftReport {
+	report := &driftReport{
+		Language: c.analyzerLanguage(),
+		Drifted:  make([]*indexDrift, 0, 10),
+	}
+	// add the drift of the item, if it has any
+	compare := func(kind string, key *datastore.Key, name, status string,
+		stored []string, wanted map[string]bool) {
+		drift := &indexDrift{
+			Kind:    kind,
+			Id:      key.Encode(),
+			Name:    name,
+			Pending: status == indexPending,
+			Missing: make([]string, 0),
+			Extra:   make([]string, 0),
+		}
+		have := make(map[string]bool)
+		for _, word := range stored {
+			have[word] = true
+		}
+		for word, _ := range wanted {
+			if !have[word] {
+				drift.Missing = append(drift.Missing, word)
+			}
+		}
+		for word, _ := range have {
+			if _, found := wanted[word]; !found {
+				drift.Extra = append(drift.Extra, word)
+			}
+		}
+		if len(drift.Missing) > 0 || len(drift.Extra) > 0 {
+			sort.Strings(drift.Missing)
+			sort.Strings(drift.Extra)
+			report.Drifted = append(report.Drifted, drift)
+		}
+	}
+	dishes := make([]Dish, 0, 100)
+	keys, err := c.NewQuery("Dish").GetAll(c.c, &dishes)
+	check(err)
+	for i, _ := range dishes {
+		dish := &dishes[i]
+		compare("Dish", keys[i], dish.Name, dish.IndexStatus,
+			dish.Keywords, dishKeywords(c, keys[i], dish))
+	}
+	report.Dishes = len(dishes)
+	ings := make([]Ingredient, 0, 100)
+	keys, err = c.NewQuery("Ingredient").GetAll(c.c, &ings)
+	check(err)
+	for i, _ := range ings {
+		ing := &ings[i]
+		compare("Ingredient", keys[i], ing.Name, ing.IndexStatus,
+			ing.Keywords, ingredientKeywords(c, keys[i], ing))
+	}
+	report.Ingredients = len(ings)
+	menus := make([]Menu, 0, 100)
+	keys, err = c.NewQuery("Menu").GetAll(c.c, &menus)
+	check(err)
+	for i, _ := range menus {
+		menu := &menus[i]
+		menu.migrateDishes()
+		compare("Menu", keys[i], menu.Name, menu.IndexStatus,
+			menu.Keywords, menuKeywords(c, keys[i], menu))
+	}
+	report.Menus = len(menus)
+	return report
+}
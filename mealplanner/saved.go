@@ -0,0 +1,111 @@
+package mealplanner
+
+// saved searches, which act as collections of the dishes that match them
+
+import (
+	"appengine/datastore"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// handler for saved searches
+//  GET/POST/PUT/DELETE /saved/[<id>] work with the SavedSearch entities
+//  GET /saved/<id>/results runs the search, with optional "cursor"
+//   and "limit" parameters for paging
+//  GET /saved/<id>/dishes lists the Ids of the matching dishes, best first
+//  GET /saved/<id>/summary summarizes the matching dishes as a menu
+func savedSearchHandler(c *context) {
+	for _, action := range []string{"/results", "/dishes", "/summary"} {
+		if strings.HasSuffix(c.r.URL.Path, action) {
+			savedSearchResultsHandler(c, action)
+			return
+		}
+	}
+	// check the search can be run before it is saved
+	if c.r.Method == "POST" || c.r.Method == "PUT" {
+		body, err := ioutil.ReadAll(c.r.Body)
+		check(err)
+		saved := &SavedSearch{}
+		check(json.Unmarshal(body, saved))
+		saved.params()
+		c.r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	// use the default data handler
+	handler := newDataHandler(c, "SavedSearch", func() Ided { return &SavedSearch{} }, "Name")
+	handler.handleRequest(c.lid, nil)
+}
+
+// decode the searchParams of the saved search
+//  panics with ErrBadQuery if they can't be run
+func (self *SavedSearch) params() *searchParams {
+	sp := &searchParams{}
+	if err := json.Unmarshal([]byte(self.Params), sp); err != nil {
+		check(ErrBadQuery)
+	}
+	// searching by ingredients on hand isn't a collection of dishes
+	if len(sp.Have) > 0 || sp.MinRating < 0 || sp.MaxTotalMinutes < 0 {
+		check(ErrBadQuery)
+	}
+	// parse the query to check it
+	sp.query()
+	return sp
+}
+
+// handler to run a saved search, action is "/results", "/dishes" or "/summary"
+func savedSearchResultsHandler(c *context, action string) {
+	if c.r.Method != "GET" {
+		check(ErrUnsupported)
+	}
+	// validate the saved search key
+	key, err := datastore.DecodeKey(getActionID(c.r))
+	check(err)
+	if key.Kind() != "SavedSearch" {
+		check(ErrUnknownItem)
+	}
+	c.checkUser(key)
+	saved := &SavedSearch{}
+	err = datastore.Get(c.c, key, saved)
+	check(err)
+	sp := saved.params()
+	switch action {
+	case "/results":
+		sp.Cursor = c.r.FormValue("cursor")
+		if limitStr := c.r.FormValue("limit"); len(limitStr) > 0 {
+			sp.Limit, err = strconv.Atoi(limitStr)
+			check(err)
+		}
+		c.sendJSONNoCache(searchPageFor(c, sp))
+	case "/dishes":
+		c.sendJSONNoCache(savedSearchDishes(c, sp))
+	case "/summary":
+		ids := savedSearchDishes(c, sp)
+		dishKeys := make([]*datastore.Key, len(ids))
+		multipliers := make([]float32, len(ids))
+		for i, id := range ids {
+			dishKeys[i], err = datastore.DecodeKey(id)
+			check(err)
+			multipliers[i] = 1
+		}
+		summary := summarizeDishKeys(c, dishKeys, multipliers)
+		summary.Id = key.Encode()
+		summary.Name = saved.Name
+		c.sendJSONNoCache(summary)
+	}
+}
+
+// find the Ids of the dishes matching the search, best match first
+func savedSearchDishes(c *context, sp *searchParams) []string {
+	// saved searches work like menus, in the current library only
+	sp.AllLibraries = false
+	ranked := rankResults(c, sp, runSearch(c, sp))
+	ids := make([]string, 0, len(ranked))
+	for _, result := range ranked {
+		if result.Kind == "Dish" {
+			ids = append(ids, result.Id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,436 @@
+package mealplanner
+
+// search of the Tags and Keywords of the items in the library
+
+import (
+	"appengine/datastore"
+	"strings"
+	"unicode"
+)
+
+// structure to read search parameters from client's post
+type searchParams struct {
+	// query in the language parsed by parseQuery, e.g.
+	//  tag:weeknight -tag:spicy (chicken OR tofu)
+	Query string
+	// list of tags that must match
+	Tags []string
+	// space/comma separated list of keywords, any of which may match
+	Word string
+	// ingredients the cook has on hand, by id or name
+	//  if given, the search finds dishes that can be made with them
+	Have []string
+	// true to leave staples (e.g. salt and oil) out when matching Have
+	IgnoreStaples bool
+	// only dishes of this DishType
+	DishType string
+	// only dishes with at least this Rating
+	MinRating int
+	// only dishes that haven't been rated, e.g. to find untried dishes
+	Unrated bool
+	// only dishes with PrepTimeMinutes + CookTimeMinutes of at most this,
+	//  0 for no limit
+	MaxTotalMinutes int
+	// only dishes with servings in these ranges, nil for any servings
+	ServingsCarb    *servingRange
+	ServingsProtein *servingRange
+	ServingsVeggies *servingRange
+	// only ingredients in this Category, and dishes using one
+	IngredientCategory string
+	// only ingredients with this Source (e.g. Vegan, Vegetarian, Animal),
+	//  and dishes made only from such ingredients, see dishSource
+	//  vegan ingredients and dishes are vegetarian too
+	IngredientSource string
+	// the most results to return, 0 for defaultSearchLimit
+	Limit int
+	// the Cursor from the previous page of results, empty for the first page
+	Cursor string
+	// true to search the user's own library and every library shared
+	//  with them, rather than just the current library
+	AllLibraries bool
+}
+
+// results of a search, map of kind -> encoded key -> count of matches
+//  the count is used to rank the items
+type searchResults map[string]map[string]uint
+
+// the most ingredients whose dishes are found with a query each, see
+//  addIngredientDishes
+const maxIngredientQueries = 20
+
+// the kinds of items that can be found by a search
+var searchKinds = []string{"Dish", "Ingredient", "Menu"}
+
+// handler for search requests, client "POST"s searchParams as JSON
+//  and receives a searchPage of ranked results
+func searchHandler(c *context) {
+	// decode the JSON search parameters
+	sp := searchParams{}
+	readJSON(c.r, &sp)
+	// searching by ingredients on hand gives a ranked list of dishes
+	if len(sp.Have) > 0 {
+		c.sendJSONNoCache(findCookableDishes(c, &sp))
+		return
+	}
+	c.sendJSONNoCache(searchPageFor(c, &sp))
+}
+
+// build the query for the search parameters, combining the Query text
+//  with the Tags and Word fields used by older clients
+// returns nil if there is nothing to search for
+func (self *searchParams) query() *queryNode {
+	parts := make([]*queryNode, 0, len(self.Tags)+2)
+	parts = append(parts, parseQuery(self.Query))
+	for _, tag := range self.Tags {
+		parts = append(parts, &queryNode{kind: queryTag, text: tag})
+	}
+	// any of the words may match
+	words := strings.FieldsFunc(self.Word, func(r rune) bool {
+		return unicode.IsSpace(r) || r == ','
+	})
+	if len(words) > 0 {
+		anyWord := &queryNode{kind: queryOr}
+		for _, word := range words {
+			anyWord.children = append(anyWord.children, &queryNode{kind: queryWord, text: word})
+		}
+		parts = append(parts, anyWord)
+	}
+	return andQueries(parts...)
+}
+
+// find the items matching the search parameters
+func runSearch(c *context, sp *searchParams) searchResults {
+	return runQuery(c, sp, sp.query())
+}
+
+// find the items matching the query and the filters of the search
+//  parameters, the query is used in place of sp.query()
+func runQuery(c *context, sp *searchParams, query *queryNode) searchResults {
+	filtered := sp.filtered()
+	if query == nil && !filtered {
+		return make(searchResults)
+	}
+	searcher := &searcher{c: c}
+	var results searchResults
+	if query == nil {
+		// filters alone search everything
+		results = searcher.universe().copy()
+	} else {
+		results = searcher.eval(query)
+	}
+	if filtered {
+		results = sp.filter(c, results)
+	}
+	return results
+}
+
+// evaluates a parsed query against the library
+type searcher struct {
+	c *context
+	// every item that can be found, fetched the first time it's needed
+	all searchResults
+}
+
+// find the items matching the query node
+func (self *searcher) eval(node *queryNode) searchResults {
+	switch node.kind {
+	case queryAnd:
+		return self.evalAnd(node)
+	case queryOr:
+		results := make(searchResults)
+		for _, child := range node.children {
+			results.union(self.eval(child))
+		}
+		return results
+	case queryNot:
+		// without other terms to narrow, everything else matches
+		return self.universe().subtract(self.eval(node.children[0]))
+	case queryWord:
+		return self.evalWord(node.text)
+	case queryPhrase:
+		return self.evalPhrase(node.text)
+	case queryTag:
+		return self.evalTag(node.text)
+	}
+	check(ErrBadQuery)
+	return nil
+}
+
+// find the items matching all of the children of the node
+func (self *searcher) evalAnd(node *queryNode) searchResults {
+	var results searchResults
+	negated := make([]*queryNode, 0, len(node.children))
+	for _, child := range node.children {
+		// negated terms are removed once the others are matched
+		if child.kind == queryNot {
+			negated = append(negated, child.children[0])
+			continue
+		}
+		childResults := self.eval(child)
+		if results == nil {
+			results = childResults
+		} else {
+			results = results.intersect(childResults)
+		}
+	}
+	if results == nil {
+		// only negated terms
+		results = self.universe().copy()
+	}
+	for _, child := range negated {
+		results = results.subtract(self.eval(child))
+	}
+	return results
+}
+
+// find the items with any of the keywords from the text
+//  dishes using matching ingredients are found too
+func (self *searcher) evalWord(text string) searchResults {
+	// words joined by punctuation, or written in a script without
+	//  spaces (e.g. 豆腐), must be found together
+	if len(self.c.analyzer().tokenize(text)) > 1 {
+		return self.evalPhrase(text)
+	}
+	terms := make(map[string]bool)
+	addWords(self.c.analyzer(), text, terms)
+	if len(terms) == 0 {
+		// nothing we index, so it can't narrow the search
+		return self.universe().copy()
+	}
+	results := make(searchResults)
+	for target, _ := range terms {
+		results.union(self.having("Keywords", target))
+	}
+	self.addIngredientDishes(results)
+	return results
+}
+
+// find the items that have all of the words of the phrase, in order
+func (self *searcher) evalPhrase(text string) searchResults {
+	a := self.c.analyzer()
+	words := a.tokenize(text)
+	// find the items that have all of the words
+	var results searchResults
+	for _, word := range words {
+		terms := make(map[string]bool)
+		addWords(a, word, terms)
+		if len(terms) == 0 {
+			// words that aren't indexed are only checked below
+			continue
+		}
+		wordResults := make(searchResults)
+		for target, _ := range terms {
+			wordResults.union(self.having("Keywords", target))
+		}
+		if results == nil {
+			results = wordResults
+		} else {
+			results = results.intersect(wordResults)
+		}
+	}
+	if results == nil {
+		// nothing we index, so it can't narrow the search
+		return self.universe().copy()
+	}
+	// keep only the items where the words are together
+	for kind, ids := range results {
+		keys := make([]*datastore.Key, 0, len(ids))
+		for id, _ := range ids {
+			key, err := datastore.DecodeKey(id)
+			check(err)
+			keys = append(keys, key)
+		}
+		for i, fields := range searchableFields(self.c, kind, keys) {
+			found := false
+			for _, field := range fields {
+				if containsPhrase(a, field, words) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				delete(results[kind], keys[i].Encode())
+			}
+		}
+	}
+	self.addIngredientDishes(results)
+	return results
+}
+
+// find the items with the tag
+//  a parent tag matches the tags under it, e.g. cuisine/italian matches
+//  cuisine/italian/sicilian
+func (self *searcher) evalTag(tag string) searchResults {
+	results := make(searchResults)
+	for _, kind := range searchKinds {
+		for _, key := range taggedKeys(self.c, kind, tag, true) {
+			results.add(kind, key.Encode(), 1)
+		}
+	}
+	return results
+}
+
+// find the items with the value in a list property, e.g. Tags
+func (self *searcher) having(property, value string) searchResults {
+	results := make(searchResults)
+	for _, kind := range searchKinds {
+		query := self.c.NewQuery(kind).Filter(property+" =", value).KeysOnly()
+		keys, err := query.GetAll(self.c.c, nil)
+		check(err)
+		for _, key := range keys {
+			results.add(kind, key.Encode(), 1)
+		}
+	}
+	return results
+}
+
+// carry matching ingredients forward to the dishes that use them
+//  each ingredient is queried on its own, unless there are more than
+//  maxIngredientQueries when every measured ingredient is read at once
+func (self *searcher) addIngredientDishes(results searchResults) {
+	ings := results["Ingredient"]
+	if len(ings) <= maxIngredientQueries {
+		for ing, _ := range ings {
+			ingKey, err := datastore.DecodeKey(ing)
+			check(err)
+			query := self.c.NewQuery("MeasuredIngredient").Filter("Ingredient =", ingKey).KeysOnly()
+			keys, err := query.GetAll(self.c.c, nil)
+			check(err)
+			results.addKeys(keys)
+		}
+		return
+	}
+	mis := make([]MeasuredIngredient, 0, 1000)
+	keys, err := self.c.NewQuery("MeasuredIngredient").GetAll(self.c.c, &mis)
+	check(err)
+	for i, _ := range mis {
+		if mis[i].Ingredient == nil {
+			continue
+		}
+		if _, found := ings[mis[i].Ingredient.Encode()]; found {
+			results.addKeys(keys[i : i+1])
+		}
+	}
+}
+
+// get every item that can be found in the library
+func (self *searcher) universe() searchResults {
+	if self.all == nil {
+		self.all = make(searchResults)
+		for _, kind := range searchKinds {
+			keys, err := self.c.NewQuery(kind).KeysOnly().GetAll(self.c.c, nil)
+			check(err)
+			for _, key := range keys {
+				self.all.add(kind, key.Encode(), 1)
+			}
+		}
+	}
+	return self.all
+}
+
+// get the text of the fields of the items of the kind that are
+//  searched by keyword, fetching the items together
+//  items that no longer exist have no fields
+func searchableFields(c *context, kind string, keys []*datastore.Key) [][]string {
+	fields := make([][]string, len(keys))
+	switch kind {
+	case "Dish":
+		dishes := make([]Dish, len(keys))
+		errs := getMulti(c.c, keys, dishes)
+		for i, _ := range dishes {
+			if errs[i] != datastore.ErrNoSuchEntity {
+				check(errs[i])
+				fields[i] = []string{dishes[i].Name, dishes[i].Source, dishes[i].Text}
+			}
+		}
+	case "Ingredient":
+		ings := make([]Ingredient, len(keys))
+		errs := getMulti(c.c, keys, ings)
+		for i, _ := range ings {
+			if errs[i] != datastore.ErrNoSuchEntity {
+				check(errs[i])
+				fields[i] = []string{ings[i].Name, ings[i].Category}
+			}
+		}
+	case "Menu":
+		menus := make([]Menu, len(keys))
+		errs := getMulti(c.c, keys, menus)
+		for i, _ := range menus {
+			if errs[i] != datastore.ErrNoSuchEntity {
+				check(errs[i])
+				fields[i] = []string{menus[i].Name}
+			}
+		}
+	}
+	return fields
+}
+
+// check if the words appear together, in order, in the text
+func containsPhrase(a analyzer, text string, words []string) bool {
+	phrase := " " + strings.Join(words, " ") + " "
+	return strings.Contains(" "+strings.Join(a.tokenize(text), " ")+" ", phrase)
+}
+
+// add a match for the item
+func (self searchResults) add(kind, id string, count uint) {
+	ids, ok := self[kind]
+	if !ok {
+		ids = make(map[string]uint)
+		self[kind] = ids
+	}
+	ids[id] += count
+}
+
+// add a match for the parent of each key (keys are MeasuredIngredient
+//  children of the dishes found)
+func (self searchResults) addKeys(keys []*datastore.Key) {
+	for _, key := range keys {
+		parent := key.Parent()
+		self.add(parent.Kind(), parent.Encode(), 1)
+	}
+}
+
+// add all the matches from other into these results
+func (self searchResults) union(other searchResults) {
+	for kind, ids := range other {
+		for id, count := range ids {
+			self.add(kind, id, count)
+		}
+	}
+}
+
+// create results with only the items found in both
+//  the counts are added together
+func (self searchResults) intersect(other searchResults) searchResults {
+	results := make(searchResults)
+	for kind, ids1 := range self {
+		if ids2, ok := other[kind]; ok {
+			for id, count1 := range ids1 {
+				if count2, ok := ids2[id]; ok {
+					results.add(kind, id, count1+count2)
+				}
+			}
+		}
+	}
+	return results
+}
+
+// create results without the items found in other
+func (self searchResults) subtract(other searchResults) searchResults {
+	results := make(searchResults)
+	for kind, ids := range self {
+		for id, count := range ids {
+			if _, found := other[kind][id]; !found {
+				results.add(kind, id, count)
+			}
+		}
+	}
+	return results
+}
+
+// create a copy of the results that can be changed
+func (self searchResults) copy() searchResults {
+	results := make(searchResults)
+	results.union(self)
+	return results
+}
@@ -0,0 +1,286 @@
+package mealplanner
+
+// statistics of the library, for curiosity and for finding items that
+//  need attention, and the cook history of dishes they use
+
+import (
+	"appengine"
+	"appengine/datastore"
+	"sort"
+	"time"
+)
+
+// how many items are in each list of the stats
+const statsListLength = 10
+
+// a dish in the stats, sent to the client as JSON
+type dishStat struct {
+	Id          string
+	Name        string
+	Rating      int
+	CookedCount int
+	LastCooked  time.Time
+}
+
+// an ingredient in the stats, sent to the client as JSON
+type ingredientStat struct {
+	Id   string
+	Name string
+	// the number of dishes using the ingredient
+	Dishes int
+}
+
+// the average times of the dishes of a DishType, sent as JSON
+//  dishes without a time aren't included in its average
+type dishTypeTimes struct {
+	DishType string
+	Dishes   int
+	// the averages, 0 if no dish of the type has the time
+	AvgPrepMinutes float64
+	AvgCookMinutes float64
+}
+
+// statistics of the library, sent to the client as JSON
+type libraryStats struct {
+	// the number of items of each kind, e.g. Dish or Pairing
+	Counts map[string]int
+	// the best rated dishes, and the dishes cooked most often
+	TopRated   []*dishStat
+	MostCooked []*dishStat
+	// the average times by DishType, sorted by DishType
+	Times []*dishTypeTimes
+	// the ingredients used by the most dishes
+	MostUsedIngredients []*ingredientStat
+	// ingredients no dish uses
+	UnusedIngredients []*ingredientStat
+	// dishes without measured ingredients, or without tags
+	NoIngredients []*dishStat
+	NoTags        []*dishStat
+}
+
+// the kinds of items counted by the stats
+var statsKinds = []string{"Dish", "Ingredient", "Menu", "MeasuredIngredient",
+	"Pairing", "PairingType", "SavedSearch"}
+
+// sort dish stats by rating, best first, then by name
+type dishStatsByRating []*dishStat
+
+func (self dishStatsByRating) Len() int {
+	return len(self)
+}
+func (self dishStatsByRating) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+func (self dishStatsByRating) Less(i, j int) bool {
+	if self[i].Rating != self[j].Rating {
+		return self[i].Rating > self[j].Rating
+	}
+	return self[i].Name < self[j].Name
+}
+
+// sort dish stats by how often they were cooked, most first, then
+//  the most recently cooked
+type dishStatsByCooked []*dishStat
+
+func (self dishStatsByCooked) Len() int {
+	return len(self)
+}
+func (self dishStatsByCooked) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+func (self dishStatsByCooked) Less(i, j int) bool {
+	if self[i].CookedCount != self[j].CookedCount {
+		return self[i].CookedCount > self[j].CookedCount
+	}
+	return self[i].LastCooked.After(self[j].LastCooked)
+}
+
+// sort ingredient stats by how many dishes use them, most first, then
+//  by name
+type ingredientStatsByUse []*ingredientStat
+
+func (self ingredientStatsByUse) Len() int {
+	return len(self)
+}
+func (self ingredientStatsByUse) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+func (self ingredientStatsByUse) Less(i, j int) bool {
+	if self[i].Dishes != self[j].Dishes {
+		return self[i].Dishes > self[j].Dishes
+	}
+	return self[i].Name < self[j].Name
+}
+
+// sort dish type times by DishType
+type dishTypeTimesByType []*dishTypeTimes
+
+func (self dishTypeTimesByType) Len() int {
+	return len(self)
+}
+func (self dishTypeTimesByType) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+func (self dishTypeTimesByType) Less(i, j int) bool {
+	return self[i].DishType < self[j].DishType
+}
+
+// handler for the statistics of the current library, GET /stats
+func statsHandler(c *context) {
+	if c.r.Method != "GET" {
+		check(ErrUnsupported)
+	}
+	c.sendJSONNoCache(gatherStats(c))
+}
+
+// gather the statistics of the current library
+func gatherStats(c *context) *libraryStats {
+	stats := &libraryStats{
+		Counts:            make(map[string]int),
+		Times:             make([]*dishTypeTimes, 0, 10),
+		UnusedIngredients: make([]*ingredientStat, 0, 10),
+		NoIngredients:     make([]*dishStat, 0, 10),
+		NoTags:            make([]*dishStat, 0, 10),
+	}
+	for _, kind := range statsKinds {
+		count, err := c.NewQuery(kind).KeysOnly().Count(c.c)
+		check(err)
+		stats.Counts[kind] = count
+	}
+
+	// count the dishes using each ingredient, once per dish
+	mis := make([]MeasuredIngredient, 0, 100)
+	miKeys, err := c.NewQuery("MeasuredIngredient").GetAll(c.c, &mis)
+	check(err)
+	uses := make(map[string]map[string]bool)
+	withIngredients := make(map[string]bool)
+	for i, _ := range mis {
+		if mis[i].Ingredient == nil {
+			continue
+		}
+		dishId := miKeys[i].Parent().Encode()
+		ingId := mis[i].Ingredient.Encode()
+		if _, found := uses[ingId]; !found {
+			uses[ingId] = make(map[string]bool)
+		}
+		uses[ingId][dishId] = true
+		withIngredients[dishId] = true
+	}
+
+	// go through the dishes
+	dishes := make([]Dish, 0, 100)
+	keys, err := c.NewQuery("Dish").GetAll(c.c, &dishes)
+	check(err)
+	rated := make([]*dishStat, 0, len(dishes))
+	cooked := make([]*dishStat, 0, len(dishes))
+	times := make(map[string]*dishTypeTimes)
+	prepCounts := make(map[string]int)
+	cookCounts := make(map[string]int)
+	for i, _ := range dishes {
+		dish := &dishes[i]
+		stat := &dishStat{keys[i].Encode(), dish.Name, dish.Rating,
+			dish.CookedCount, dish.LastCooked}
+		if dish.Rating > 0 {
+			rated = append(rated, stat)
+		}
+		if dish.CookedCount > 0 {
+			cooked = append(cooked, stat)
+		}
+		if !withIngredients[stat.Id] {
+			stats.NoIngredients = append(stats.NoIngredients, stat)
+		}
+		if len(dish.Tags) == 0 {
+			stats.NoTags = append(stats.NoTags, stat)
+		}
+		t, found := times[dish.DishType]
+		if !found {
+			t = &dishTypeTimes{DishType: dish.DishType}
+			times[dish.DishType] = t
+			stats.Times = append(stats.Times, t)
+		}
+		t.Dishes++
+		// sum the times, they are divided by their counts below
+		if dish.PrepTimeMinutes > 0 {
+			t.AvgPrepMinutes += float64(dish.PrepTimeMinutes)
+			prepCounts[dish.DishType]++
+		}
+		if dish.CookTimeMinutes > 0 {
+			t.AvgCookMinutes += float64(dish.CookTimeMinutes)
+			cookCounts[dish.DishType]++
+		}
+	}
+	sort.Sort(dishStatsByRating(rated))
+	if len(rated) > statsListLength {
+		rated = rated[:statsListLength]
+	}
+	stats.TopRated = rated
+	sort.Sort(dishStatsByCooked(cooked))
+	if len(cooked) > statsListLength {
+		cooked = cooked[:statsListLength]
+	}
+	stats.MostCooked = cooked
+	for _, t := range stats.Times {
+		if count := prepCounts[t.DishType]; count > 0 {
+			t.AvgPrepMinutes /= float64(count)
+		}
+		if count := cookCounts[t.DishType]; count > 0 {
+			t.AvgCookMinutes /= float64(count)
+		}
+	}
+	sort.Sort(dishTypeTimesByType(stats.Times))
+	sort.Sort(dishStatsByRating(stats.NoIngredients))
+	sort.Sort(dishStatsByRating(stats.NoTags))
+
+	// go through the ingredients
+	ings := make([]Ingredient, 0, 100)
+	keys, err = c.NewQuery("Ingredient").GetAll(c.c, &ings)
+	check(err)
+	used := make([]*ingredientStat, 0, len(ings))
+	for i, _ := range ings {
+		id := keys[i].Encode()
+		stat := &ingredientStat{id, ings[i].Name, len(uses[id])}
+		if stat.Dishes == 0 {
+			stats.UnusedIngredients = append(stats.UnusedIngredients, stat)
+		} else {
+			used = append(used, stat)
+		}
+	}
+	sort.Sort(ingredientStatsByUse(used))
+	if len(used) > statsListLength {
+		used = used[:statsListLength]
+	}
+	stats.MostUsedIngredients = used
+	sort.Sort(ingredientStatsByUse(stats.UnusedIngredients))
+	return stats
+}
+
+// handler to record that a dish was cooked, POST /dish/<id>/cooked
+//  sends back the dish with its CookedCount and LastCooked updated
+func cookedHandler(c *context) {
+	if c.r.Method != "POST" {
+		check(ErrUnsupported)
+	}
+	key, err := datastore.DecodeKey(getActionID(c.r))
+	check(err)
+	if key.Kind() != "Dish" {
+		check(ErrUnknownItem)
+	}
+	c.checkUser(key)
+	dish := &Dish{}
+	err = datastore.RunInTransaction(c.c, func(tc appengine.Context) error {
+		if err := datastore.Get(tc, key, dish); err != nil {
+			return err
+		}
+		dish.CookedCount++
+		dish.LastCooked = time.Now()
+		_, err := datastore.Put(tc, key, dish)
+		return err
+	}, nil)
+	if err == datastore.ErrNoSuchEntity {
+		check(ErrUnknownItem)
+	}
+	check(err)
+	clearItemCache(c, key)
+	dish.SetID(key.Encode())
+	c.sendJSONNoCache(dish)
+}
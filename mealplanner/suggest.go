@@ -0,0 +1,417 @@
+package mealplanner
+
+// handlers suggesting dishes that are related to a dish
+
+import (
+	"appengine/datastore"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// a dish suggested to go with another because they share menus,
+//  sent to the client as JSON
+type menuPairingSuggestion struct {
+	// Id of the suggested dish
+	Dish string
+	// Name of the suggested dish
+	Name string
+	// how many menus include both dishes
+	Count int
+	// fraction of the menus with the dish that also include the suggested dish
+	Score float32
+	// names of the menus that include both dishes
+	Menus []string
+	// pairings already made from the dish to the suggested dish
+	Pairings []Pairing
+}
+
+// sort suggestions with the most menus in common first, then
+//  those that are already paired
+type suggestionsByCount []*menuPairingSuggestion
+
+func (self suggestionsByCount) Len() int {
+	return len(self)
+}
+func (self suggestionsByCount) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+func (self suggestionsByCount) Less(i, j int) bool {
+	if self[i].Count != self[j].Count {
+		return self[i].Count > self[j].Count
+	}
+	if len(self[i].Pairings) != len(self[j].Pairings) {
+		return len(self[i].Pairings) > len(self[j].Pairings)
+	}
+	return self[i].Name < self[j].Name
+}
+
+// handler for dishes that appear in menus with a dish, the library
+//  has no record of the meals cooked, so sharing a menu is the only
+//  sign that dishes are eaten together
+//  GET /dish/<id>/menupairs/ lists the suggestions
+//  POST /dish/<id>/menupairs/<other id> turns a suggestion into a pairing
+func menuPairingsHandler(c *context) {
+	// validate the dish key
+	dishKey, err := datastore.DecodeKey(getParentID(c.r))
+	check(err)
+	c.checkUser(dishKey)
+	switch c.r.Method {
+	case "GET":
+		c.sendJSONNoCache(findMenuPairingSuggestions(c, dishKey))
+	case "POST":
+		promoteSuggestion(c, dishKey)
+	default:
+		check(ErrUnsupported)
+	}
+}
+
+// find the dishes that share menus with the dish or are already paired with it
+func findMenuPairingSuggestions(c *context, dishKey *datastore.Key) []*menuPairingSuggestion {
+	suggestions := make(map[string]*menuPairingSuggestion)
+	otherKeys := make([]*datastore.Key, 0, 20)
+	// get or create the suggestion for the other dish
+	suggestionFor := func(other *datastore.Key) *menuPairingSuggestion {
+		id := other.Encode()
+		suggestion, found := suggestions[id]
+		if !found {
+			otherKeys = append(otherKeys, other)
+			suggestion = &menuPairingSuggestion{
+				Dish:     id,
+				Menus:    make([]string, 0, 4),
+				Pairings: make([]Pairing, 0, 2),
+			}
+			suggestions[id] = suggestion
+		}
+		return suggestion
+	}
+	// count the other dishes in each menu with this dish
+	query := c.NewQuery("Menu").Filter("Items.Dish =", dishKey)
+	iter := query.Run(c.c)
+	menuCount := 0
+	menu := &Menu{}
+	for _, err := iter.Next(menu); err != datastore.Done; _, err = iter.Next(menu) {
+		check(err)
+		menuCount++
+		counted := make(map[string]bool)
+		for _, item := range menu.Items {
+			if item.Dish == nil || dishKey.Equal(item.Dish) || counted[item.Dish.Encode()] {
+				continue
+			}
+			counted[item.Dish.Encode()] = true
+			suggestion := suggestionFor(item.Dish)
+			suggestion.Count++
+			suggestion.Menus = append(suggestion.Menus, menu.Name)
+		}
+		menu = &Menu{}
+	}
+	// add the explicit pairings
+	pairings := make([]Pairing, 0, 20)
+	pkeys, err := datastore.NewQuery("Pairing").Ancestor(dishKey).GetAll(c.c, &pairings)
+	check(err)
+	for i, _ := range pairings {
+		pairings[i].SetID(pkeys[i].Encode())
+		suggestion := suggestionFor(pairings[i].Other)
+		suggestion.Pairings = append(suggestion.Pairings, pairings[i])
+	}
+	// fill in the names and scores, dropping dishes that no longer exist
+	dishes := make([]Dish, len(otherKeys))
+	errs := getMulti(c.c, otherKeys, dishes)
+	results := make([]*menuPairingSuggestion, 0, len(suggestions))
+	for i, other := range otherKeys {
+		if errs[i] == datastore.ErrNoSuchEntity {
+			continue
+		}
+		check(errs[i])
+		suggestion := suggestions[other.Encode()]
+		suggestion.Name = dishes[i].Name
+		if menuCount > 0 {
+			suggestion.Score = float32(suggestion.Count) / float32(menuCount)
+		}
+		results = append(results, suggestion)
+	}
+	sort.Sort(suggestionsByCount(results))
+	return results
+}
+
+// create a pairing from the dish to the other dish in the URL
+//  the client may post a Pairing to choose the type, notes and strength
+//  otherwise the pairing will be "Together"
+func promoteSuggestion(c *context, dishKey *datastore.Key) {
+	otherKey, err := datastore.DecodeKey(getID(c.r))
+	check(err)
+	pairing := &Pairing{}
+	err = json.NewDecoder(c.r.Body).Decode(pairing)
+	if err != nil && err != io.EOF {
+		check(err)
+	}
+	pairing.Other = otherKey
+	if len(pairing.Description) == 0 {
+		pairing.Description = defaultPairingType(c)
+	}
+	addPairing(c, dishKey, pairing)
+	clearPairingCache(c, dishKey, nil)
+	c.sendJSONNoCache(pairing)
+}
+
+// the pairing type used when the client doesn't choose one
+//  "Together" if the library has it, otherwise the first type
+func defaultPairingType(c *context) string {
+	types := getPairingTypes(c)
+	for _, pairingType := range types {
+		if pairingType.Name == "Together" {
+			return pairingType.Name
+		}
+	}
+	if len(types) == 0 {
+		check(ErrInvalidPairing)
+	}
+	return types[0].Name
+}
+
+// a dish similar to another, sent to the client as JSON
+type similarDish struct {
+	// Id of the similar dish
+	Dish string
+	// Name of the similar dish
+	Name string
+	// DishType of the similar dish
+	DishType string
+	// Rating of the similar dish
+	Rating int
+	// how similar the dishes are, higher is more similar
+	Score float32
+	// how many ingredients the dishes share
+	SharedIngredients int
+	// the tags the dishes share
+	SharedTags []string
+	// true if the dishes have the same DishType
+	SameDishType bool
+	// how many keywords the dishes share
+	SharedKeywords int
+	// readable explanation of why the dish is similar,
+	//  e.g. "shares 5 ingredients, tag 'weeknight'"
+	Reason string
+}
+
+// how much each kind of overlap adds to the score of a similar dish
+const (
+	similarIngredientWeight = 3
+	similarTagWeight        = 2
+	similarDishTypeWeight   = 1
+	similarKeywordWeight    = 0.5
+)
+
+// the number of similar dishes returned if the client doesn't give a limit
+const defaultSimilarLimit = 10
+
+// the most keywords of a dish looked up to find similar dishes, each
+//  is a query
+const maxSimilarKeywords = 20
+
+// sort words with the longest first, longer keywords are usually more
+//  specific to a dish
+type wordsByLength []string
+
+func (self wordsByLength) Len() int {
+	return len(self)
+}
+func (self wordsByLength) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+func (self wordsByLength) Less(i, j int) bool {
+	if len(self[i]) != len(self[j]) {
+		return len(self[i]) > len(self[j])
+	}
+	return self[i] < self[j]
+}
+
+// sort similar dishes with the highest score first
+type similarByScore []*similarDish
+
+func (self similarByScore) Len() int {
+	return len(self)
+}
+func (self similarByScore) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+func (self similarByScore) Less(i, j int) bool {
+	if self[i].Score != self[j].Score {
+		return self[i].Score > self[j].Score
+	}
+	return self[i].Name < self[j].Name
+}
+
+// handler for other dishes like the dish, /dish/<id>/similar
+//  optional "limit" parameter sets how many dishes to return
+func similarDishesHandler(c *context) {
+	if c.r.Method != "GET" {
+		check(ErrUnsupported)
+	}
+	// validate the dish key
+	dishKey, err := datastore.DecodeKey(getActionID(c.r))
+	check(err)
+	if dishKey.Kind() != "Dish" {
+		check(ErrUnknownItem)
+	}
+	c.checkUser(dishKey)
+	limit := defaultSimilarLimit
+	if limitStr := c.r.FormValue("limit"); len(limitStr) > 0 {
+		limit, err = strconv.Atoi(limitStr)
+		check(err)
+		if limit < 0 {
+			check(ErrUnsupported)
+		}
+	}
+	dish := &Dish{}
+	err = datastore.Get(c.c, dishKey, dish)
+	check(err)
+	similar := findSimilarDishes(c, dishKey, dish)
+	if len(similar) > limit {
+		similar = similar[:limit]
+	}
+	c.sendJSONNoCache(similar)
+}
+
+// find the dishes that overlap with the dish, most similar first
+func findSimilarDishes(c *context, dishKey *datastore.Key, dish *Dish) []*similarDish {
+	similar := make(map[string]*similarDish)
+	// get or create the entry for the other dish
+	similarFor := func(other *datastore.Key) *similarDish {
+		id := other.Encode()
+		entry, found := similar[id]
+		if !found {
+			entry = &similarDish{Dish: id, SharedTags: make([]string, 0, 4)}
+			similar[id] = entry
+		}
+		return entry
+	}
+	// dishes sharing ingredients
+	query := datastore.NewQuery("MeasuredIngredient").Ancestor(dishKey)
+	mis := make([]MeasuredIngredient, 0, 20)
+	_, err := query.GetAll(c.c, &mis)
+	check(err)
+	seenIngredients := make(map[string]bool)
+	for _, mi := range mis {
+		if seenIngredients[mi.Ingredient.Encode()] {
+			continue
+		}
+		seenIngredients[mi.Ingredient.Encode()] = true
+		query = c.NewQuery("MeasuredIngredient").Filter("Ingredient =", mi.Ingredient).KeysOnly()
+		keys, err := query.GetAll(c.c, nil)
+		check(err)
+		seenDishes := make(map[string]bool)
+		for _, key := range keys {
+			other := key.Parent()
+			if other.Equal(dishKey) || seenDishes[other.Encode()] {
+				continue
+			}
+			seenDishes[other.Encode()] = true
+			similarFor(other).SharedIngredients++
+		}
+	}
+	// dishes sharing tags or keywords
+	for _, property := range []string{"Tags", "Keywords"} {
+		words := dish.Tags
+		if property == "Keywords" {
+			words = make([]string, len(dish.Keywords))
+			copy(words, dish.Keywords)
+			sort.Sort(wordsByLength(words))
+			if len(words) > maxSimilarKeywords {
+				words = words[:maxSimilarKeywords]
+			}
+		}
+		for _, word := range words {
+			query = c.NewQuery("Dish").Filter(property+" =", word).KeysOnly()
+			keys, err := query.GetAll(c.c, nil)
+			check(err)
+			for _, other := range keys {
+				if other.Equal(dishKey) {
+					continue
+				}
+				entry := similarFor(other)
+				if property == "Tags" {
+					entry.SharedTags = append(entry.SharedTags, word)
+				} else {
+					entry.SharedKeywords++
+				}
+			}
+		}
+	}
+	// dishes of the same type
+	if len(dish.DishType) > 0 {
+		query = c.NewQuery("Dish").Filter("DishType =", dish.DishType).KeysOnly()
+		keys, err := query.GetAll(c.c, nil)
+		check(err)
+		for _, other := range keys {
+			if !other.Equal(dishKey) {
+				similarFor(other).SameDishType = true
+			}
+		}
+	}
+	// fill in the scores
+	entries := make([]*similarDish, 0, len(similar))
+	otherKeys := make([]*datastore.Key, 0, len(similar))
+	for _, entry := range similar {
+		entry.Score = similarIngredientWeight*float32(entry.SharedIngredients) +
+			similarTagWeight*float32(len(entry.SharedTags)) +
+			similarKeywordWeight*float32(entry.SharedKeywords)
+		if entry.SameDishType {
+			entry.Score += similarDishTypeWeight
+		}
+		// skip dishes that only share a type, that is nearly every dish
+		if entry.Score == similarDishTypeWeight && entry.SameDishType {
+			continue
+		}
+		other, err := datastore.DecodeKey(entry.Dish)
+		check(err)
+		entries = append(entries, entry)
+		otherKeys = append(otherKeys, other)
+	}
+	// fill in the details, dropping dishes that no longer exist
+	dishes := make([]Dish, len(otherKeys))
+	errs := getMulti(c.c, otherKeys, dishes)
+	results := make([]*similarDish, 0, len(entries))
+	for i, entry := range entries {
+		if errs[i] == datastore.ErrNoSuchEntity {
+			continue
+		}
+		check(errs[i])
+		entry.Name = dishes[i].Name
+		entry.DishType = dishes[i].DishType
+		entry.Rating = dishes[i].Rating
+		entry.Reason = entry.explain()
+		results = append(results, entry)
+	}
+	sort.Sort(similarByScore(results))
+	return results
+}
+
+// build the readable explanation of why the dish is similar
+func (self *similarDish) explain() string {
+	reasons := make([]string, 0, 4)
+	switch self.SharedIngredients {
+	case 0:
+	case 1:
+		reasons = append(reasons, "shares 1 ingredient")
+	default:
+		reasons = append(reasons, fmt.Sprintf("shares %v ingredients", self.SharedIngredients))
+	}
+	for _, tag := range self.SharedTags {
+		reasons = append(reasons, fmt.Sprintf("tag '%v'", tag))
+	}
+	if self.SameDishType {
+		reasons = append(reasons, "also a "+self.DishType)
+	}
+	switch self.SharedKeywords {
+	case 0:
+	case 1:
+		reasons = append(reasons, "1 keyword in common")
+	default:
+		reasons = append(reasons, fmt.Sprintf("%v keywords in common", self.SharedKeywords))
+	}
+	return strings.Join(reasons, ", ")
+}
@@ -0,0 +1,32 @@
+package mealplanner
+
+import (
+	"testing"
+)
+
+func TestSimilarDishExplain(t *testing.T) {
+	tests := []struct {
+		dish similarDish
+		want string
+	}{
+		{similarDish{}, ""},
+		{similarDish{SharedIngredients: 1}, "shares 1 ingredient"},
+		{similarDish{SharedIngredients: 5}, "shares 5 ingredients"},
+		{similarDish{SharedTags: []string{"weeknight"}}, "tag 'weeknight'"},
+		{similarDish{SharedTags: []string{"weeknight", "cuisine/thai"}},
+			"tag 'weeknight', tag 'cuisine/thai'"},
+		{similarDish{DishType: "Entree", SameDishType: true}, "also a Entree"},
+		// the DishType is only a reason if it is the same
+		{similarDish{DishType: "Side"}, ""},
+		{similarDish{SharedKeywords: 1}, "1 keyword in common"},
+		{similarDish{SharedKeywords: 3}, "3 keywords in common"},
+		{similarDish{SharedIngredients: 2, SharedTags: []string{"quick"},
+			DishType: "Side", SameDishType: true, SharedKeywords: 4},
+			"shares 2 ingredients, tag 'quick', also a Side, 4 keywords in common"},
+	}
+	for _, test := range tests {
+		if got := test.dish.explain(); got != test.want {
+			t.Errorf("explain(%+v) = %q, want %q", test.dish, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,445 @@
+package mealplanner
+
+// tags of dishes, ingredients and menus, kept in the Tags of each item
+//  and presented to the client as a collection of Words, and management
+//  of the tags used across the library
+
+import (
+	"appengine"
+	"appengine/datastore"
+	"encoding/base64"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// separates the parts of a hierarchical tag, e.g. cuisine/italian/sicilian
+//  is a child of cuisine/italian
+const tagSeparator = "/"
+
+// a tag and its children, sent to the client as JSON by /tags
+type tagNode struct {
+	// the last part of the tag, e.g. sicilian
+	Name string
+	// the whole tag, e.g. cuisine/italian/sicilian
+	Tag string
+	// true if items have the tag itself, rather than only its children
+	Used bool
+	// the tags under this one, sorted by Name
+	Children []*tagNode
+}
+
+// clean up a tag the user typed, trimming spaces from it and each of
+//  its parts, and dropping empty parts, e.g. " diet / low-carb/" is
+//  diet/low-carb
+func normalizeTag(tag string) string {
+	parts := strings.Split(tag, tagSeparator)
+	kept := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); len(part) > 0 {
+			kept = append(kept, part)
+		}
+	}
+	return strings.Join(kept, tagSeparator)
+}
+
+// check if the tag is the parent tag or one of its descendants
+func isTagOrChild(tag, parent string) bool {
+	return tag == parent || strings.HasPrefix(tag, parent+tagSeparator)
+}
+
+// build the tree of the tags, the parents of the tags are added even if
+//  no item uses them directly
+func tagTree(tags []string) []*tagNode {
+	sort.Strings(tags)
+	root := &tagNode{Children: make([]*tagNode, 0, 10)}
+	nodes := make(map[string]*tagNode)
+	for _, tag := range tags {
+		parent := root
+		parts := strings.Split(tag, tagSeparator)
+		for i, _ := range parts {
+			path := strings.Join(parts[:i+1], tagSeparator)
+			node, found := nodes[path]
+			if !found {
+				node = &tagNode{Name: parts[i], Tag: path, Children: make([]*tagNode, 0)}
+				nodes[path] = node
+				parent.Children = append(parent.Children, node)
+			}
+			parent = node
+		}
+		parent.Used = true
+	}
+	return root.Children
+}
+
+// get the id the client uses for a tag, made from its text so that
+//  tags don't need to be stored separately
+func tagID(tag string) string {
+	return base64.URLEncoding.EncodeToString([]byte(tag))
+}
+
+// get the tag from its id, see tagID
+func tagFromID(id string) string {
+	tag, err := base64.URLEncoding.DecodeString(id)
+	if err != nil {
+		check(ErrUnknownItem)
+	}
+	return string(tag)
+}
+
+// make the list of Words the client expects for the tags or keywords
+func tagWords(tags []string) []Word {
+	words := make([]Word, len(tags))
+	for i, tag := range tags {
+		words[i] = Word{tagID(tag), tag}
+	}
+	return words
+}
+
+// check if the list of tags has the tag
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// fetch the dish, ingredient or menu with the key
+func getTaggedItem(c *context, key *datastore.Key) taggedItem {
+	item := newTaggedItem(key.Kind())
+	err := datastore.Get(c.c, key, item)
+	if err == datastore.ErrNoSuchEntity {
+		check(ErrUnknownItem)
+	}
+	check(err)
+	return item
+}
+
+// handler for the tags of an item (parent), as a collection of Words
+//  e.g. /dish/<id>/tags/ and /dish/<id>/tags/<tag id>
+func tagsHandler(c *context) {
+	key, err := datastore.DecodeKey(getParentID(c.r))
+	check(err)
+	c.checkUser(key)
+	id := getID(c.r)
+	switch c.r.Method {
+	case "GET":
+		tags := getTaggedItem(c, key).tags()
+		if len(id) == 0 {
+			c.sendJSON(tagWords(tags))
+			return
+		}
+		tag := tagFromID(id)
+		if !hasTag(tags, tag) {
+			check(ErrUnknownItem)
+		}
+		c.sendJSON(&Word{id, tag})
+	case "POST":
+		// add a new tag
+		if len(id) > 0 {
+			check(ErrUnsupported)
+		}
+		word := Word{}
+		readJSON(c.r, &word)
+		tag := normalizeTag(word.Word)
+		if len(tag) == 0 {
+			check(ErrUnsupported)
+		}
+		changeTags(c, key, func(tags []string) []string {
+			if hasTag(tags, tag) {
+				return tags
+			}
+			return append(tags, tag)
+		})
+		queueIndex(c, key)
+		c.sendJSON(&Word{tagID(tag), tag})
+	case "PUT":
+		// change the text of a tag, which changes its id too
+		old := tagFromID(id)
+		word := Word{}
+		readJSON(c.r, &word)
+		tag := normalizeTag(word.Word)
+		if len(tag) == 0 {
+			check(ErrUnsupported)
+		}
+		changeTags(c, key, func(tags []string) []string {
+			return replaceTag(tags, old, tag)
+		})
+		queueIndex(c, key)
+		c.sendJSONNoCache(&Word{tagID(tag), tag})
+	case "DELETE":
+		old := tagFromID(id)
+		changeTags(c, key, func(tags []string) []string {
+			return replaceTag(tags, old, "")
+		})
+		queueIndex(c, key)
+	default:
+		check(ErrUnsupported)
+	}
+}
+
+// replace the tag old in the list with tag, or remove it if tag is empty
+//  returns the new list of tags
+func replaceTag(tags []string, old, tag string) []string {
+	newTags := make([]string, 0, len(tags))
+	for _, t := range tags {
+		if t == old {
+			t = tag
+		}
+		if len(t) > 0 && !hasTag(newTags, t) {
+			newTags = append(newTags, t)
+		}
+	}
+	return newTags
+}
+
+// change the tags of the item in a transaction, marking its keywords
+//  indexPending, the caller queues the keywords to be updated
+//  change is given the current tags, and returns the new ones
+func changeTags(c *context, key *datastore.Key, change func(tags []string) []string) {
+	err := datastore.RunInTransaction(c.c, func(tc appengine.Context) error {
+		item := newTaggedItem(key.Kind())
+		if err := datastore.Get(tc, key, item); err != nil {
+			return err
+		}
+		item.setTags(change(item.tags()))
+		item.setIndexStatus(indexPending)
+		_, err := datastore.Put(tc, key, item)
+		return err
+	}, nil)
+	if err == datastore.ErrNoSuchEntity {
+		check(ErrUnknownItem)
+	}
+	check(err)
+	clearItemCache(c, key)
+}
+
+// handler to see the keywords of an item (parent), for debugging
+func keywordsHandler(c *context) {
+	if c.r.Method != "GET" {
+		check(ErrUnsupported)
+	}
+	key, err := datastore.DecodeKey(getParentID(c.r))
+	check(err)
+	c.checkUser(key)
+	c.sendJSON(tagWords(getTaggedItem(c, key).keywords()))
+}
+
+// how many items of each kind have a tag, sent to the client as JSON
+type tagCount struct {
+	Tag         string
+	Dishes      int
+	Ingredients int
+	Menus       int
+	// the number of items of any kind with the tag
+	Total int
+}
+
+// sort tag counts by the tag
+type tagCountsByTag []*tagCount
+
+func (self tagCountsByTag) Len() int {
+	return len(self)
+}
+func (self tagCountsByTag) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+func (self tagCountsByTag) Less(i, j int) bool {
+	return self[i].Tag < self[j].Tag
+}
+
+// request to merge tags, client "POST"s as JSON to /tags/merge
+type tagMerge struct {
+	// the tags to replace
+	From []string
+	// the tag they are replaced with
+	To string
+}
+
+// the result of changing a tag across the library, sent as JSON
+type tagChangeReport struct {
+	// the number of items whose tags changed
+	Items int
+}
+
+// handler to manage the tags of the whole library
+//  GET /tags/counts lists each tag with the number of items using it
+//  PUT /tags/<tag id> renames the tag to the Word sent as JSON
+//  POST /tags/merge replaces the tags of a tagMerge with one tag
+//  DELETE /tags/<tag id> removes the tag from every item
+// renaming or merging a tag moves its children too, deleting a tag
+//  removes its children
+func libraryTagsHandler(c *context) {
+	id := getID(c.r)
+	switch {
+	case c.r.Method == "GET" && id == "counts":
+		c.sendJSONNoCache(countTags(c))
+	case c.r.Method == "POST" && id == "merge":
+		merge := tagMerge{}
+		readJSON(c.r, &merge)
+		merge.To = normalizeTag(merge.To)
+		if len(merge.From) == 0 || len(merge.To) == 0 {
+			check(ErrUnsupported)
+		}
+		c.sendJSONNoCache(retagLibrary(c, merge.From, merge.To))
+	case c.r.Method == "PUT" && len(id) > 0:
+		word := Word{}
+		readJSON(c.r, &word)
+		tag := normalizeTag(word.Word)
+		if len(tag) == 0 {
+			check(ErrUnsupported)
+		}
+		c.sendJSONNoCache(retagLibrary(c, []string{tagFromID(id)}, tag))
+	case c.r.Method == "DELETE" && len(id) > 0:
+		c.sendJSONNoCache(retagLibrary(c, []string{tagFromID(id)}, ""))
+	default:
+		check(ErrUnsupported)
+	}
+}
+
+// count the items of each kind with each tag
+func countTags(c *context) []*tagCount {
+	counts := make(map[string]*tagCount)
+	for _, kind := range searchKinds {
+		iter := c.NewQuery(kind).Run(c.c)
+		item := newTaggedItem(kind)
+		for _, err := iter.Next(item); err != datastore.Done; _, err = iter.Next(item) {
+			check(err)
+			for _, tag := range item.tags() {
+				count, found := counts[tag]
+				if !found {
+					count = &tagCount{Tag: tag}
+					counts[tag] = count
+				}
+				switch kind {
+				case "Dish":
+					count.Dishes++
+				case "Ingredient":
+					count.Ingredients++
+				case "Menu":
+					count.Menus++
+				}
+				count.Total++
+			}
+			item = newTaggedItem(kind)
+		}
+	}
+	list := make([]*tagCount, 0, len(counts))
+	for _, count := range counts {
+		list = append(list, count)
+	}
+	sort.Sort(tagCountsByTag(list))
+	return list
+}
+
+// replace the tags in from with the tag to on every item that has them,
+//  moving their children under to, or remove them and their children
+//  if to is empty
+//  the items are changed in the background, in batches, the report
+//  gives the number of items queued
+func retagLibrary(c *context, from []string, to string) *tagChangeReport {
+	// find the items with any of the tags or their children
+	keys := make(map[string]*datastore.Key)
+	for _, kind := range searchKinds {
+		for _, tag := range from {
+			for _, key := range taggedKeys(c, kind, tag, true) {
+				keys[key.Encode()] = key
+			}
+		}
+	}
+	changed := make([]*datastore.Key, 0, len(keys))
+	for _, key := range keys {
+		changed = append(changed, key)
+	}
+	queueRetag(c, from, to, changed)
+	return &tagChangeReport{Items: len(changed)}
+}
+
+// queue tasks to retag the items, in batches
+func queueRetag(c *context, from []string, to string, keys []*datastore.Key) {
+	for start := 0; start < len(keys); start += indexBatchSize {
+		end := start + indexBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		params := url.Values{"library": {c.lid.Encode()}, "from": from, "to": {to}}
+		for _, key := range keys[start:end] {
+			params.Add("key", key.Encode())
+		}
+		c.workQueue().add(c, "/task/retag", params)
+	}
+}
+
+// handler for tasks to replace the tags in the "from" params with the
+//  "to" param on the items given by the "key" params, then update
+//  their keywords
+//  retagging an item twice changes nothing, so the task can be retried
+func retagTaskHandler(c *context) {
+	from := c.r.Form["from"]
+	to := c.r.FormValue("to")
+	for _, id := range c.r.Form["key"] {
+		key, err := datastore.DecodeKey(id)
+		check(err)
+		c.checkUser(key)
+		// the item was deleted after the task was queued
+		err = datastore.Get(c.c, key, newTaggedItem(key.Kind()))
+		if err == datastore.ErrNoSuchEntity {
+			continue
+		}
+		check(err)
+		changeTags(c, key, func(tags []string) []string {
+			return retagTags(tags, from, to)
+		})
+		indexItem(c, key)
+	}
+}
+
+// replace the tags in from and their children in the list of tags,
+//  moving the children under to, or remove them if to is empty
+//  returns the new list of tags
+func retagTags(tags, from []string, to string) []string {
+	for _, tag := range from {
+		for _, t := range tags {
+			if !isTagOrChild(t, tag) {
+				continue
+			}
+			if len(to) == 0 {
+				tags = replaceTag(tags, t, "")
+			} else {
+				tags = replaceTag(tags, t, to+t[len(tag):])
+			}
+		}
+	}
+	return tags
+}
+
+// find the keys of the items of the kind with the tag, and if children
+//  is true, those with tags under it
+func taggedKeys(c *context, kind, tag string, children bool) []*datastore.Key {
+	query := c.NewQuery(kind).Filter("Tags =", tag).KeysOnly()
+	keys, err := query.GetAll(c.c, nil)
+	check(err)
+	if children {
+		// the children sort after "tag/" and before "tag0", as "0"
+		//  follows the separator
+		query = c.NewQuery(kind).KeysOnly().
+			Filter("Tags >", tag+tagSeparator).
+			Filter("Tags <", tag+string(tagSeparator[0]+1))
+		childKeys, err := query.GetAll(c.c, nil)
+		check(err)
+		// items with the tag or several children are found more than once
+		found := make(map[string]bool)
+		for _, key := range keys {
+			found[key.Encode()] = true
+		}
+		for _, key := range childKeys {
+			if !found[key.Encode()] {
+				found[key.Encode()] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
@@ -0,0 +1,120 @@
+package mealplanner
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeTag(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want string
+	}{
+		{"", ""},
+		{" / ", ""},
+		{"quick", "quick"},
+		{"  quick meals ", "quick meals"},
+		{" diet / low-carb/", "diet/low-carb"},
+		{"cuisine//italian", "cuisine/italian"},
+		// case is kept
+		{"Cuisine/Italian", "Cuisine/Italian"},
+	}
+	for _, test := range tests {
+		if got := normalizeTag(test.tag); got != test.want {
+			t.Errorf("normalizeTag(%q) = %q, want %q", test.tag, got, test.want)
+		}
+	}
+}
+
+func TestIsTagOrChild(t *testing.T) {
+	tests := []struct {
+		tag, parent string
+		want        bool
+	}{
+		{"diet", "diet", true},
+		{"diet/vegan", "diet", true},
+		{"diet/vegan/raw", "diet", true},
+		{"diet/vegan/raw", "diet/vegan", true},
+		{"dietary", "diet", false},
+		{"diet", "diet/vegan", false},
+		{"cuisine/diet", "diet", false},
+	}
+	for _, test := range tests {
+		if got := isTagOrChild(test.tag, test.parent); got != test.want {
+			t.Errorf("isTagOrChild(%q, %q) = %v, want %v", test.tag, test.parent, got, test.want)
+		}
+	}
+}
+
+// write the tree as text, e.g. a*(b c*) for a used tag a with the
+//  children b, and c which is used
+func treeString(nodes []*tagNode) string {
+	text := ""
+	for i, node := range nodes {
+		if i > 0 {
+			text += " "
+		}
+		text += node.Name
+		if node.Used {
+			text += "*"
+		}
+		if len(node.Children) > 0 {
+			text += "(" + treeString(node.Children) + ")"
+		}
+	}
+	return text
+}
+
+func TestTagTree(t *testing.T) {
+	tests := []struct {
+		tags []string
+		want string
+	}{
+		{[]string{}, ""},
+		{[]string{"quick"}, "quick*"},
+		{[]string{"quick", "easy"}, "easy* quick*"},
+		// parents are added, but aren't used
+		{[]string{"cuisine/italian/sicilian"}, "cuisine(italian(sicilian*))"},
+		{[]string{"cuisine/thai", "cuisine", "cuisine/italian", "quick"},
+			"cuisine*(italian* thai*) quick*"},
+	}
+	for _, test := range tests {
+		if got := treeString(tagTree(test.tags)); got != test.want {
+			t.Errorf("tagTree(%v) = %s, want %s", test.tags, got, test.want)
+		}
+	}
+	// the whole tag is kept on each node
+	tree := tagTree([]string{"cuisine/italian"})
+	if tag := tree[0].Children[0].Tag; tag != "cuisine/italian" {
+		t.Errorf("tagTree child Tag = %q, want cuisine/italian", tag)
+	}
+}
+
+func TestRetagTags(t *testing.T) {
+	tags := []string{"quick", "cuisine", "cuisine/italian", "cuisine/italian/sicilian", "cuisines"}
+	tests := []struct {
+		from []string
+		to   string
+		want []string
+	}{
+		{[]string{"slow"}, "fast", tags},
+		{[]string{"quick"}, "fast", []string{"fast", "cuisine", "cuisine/italian",
+			"cuisine/italian/sicilian", "cuisines"}},
+		// children move with their parent
+		{[]string{"cuisine/italian"}, "italian", []string{"quick", "cuisine", "italian",
+			"italian/sicilian", "cuisines"}},
+		// children are removed with their parent
+		{[]string{"cuisine"}, "", []string{"quick", "cuisines"}},
+		// merging into a tag the item has keeps one
+		{[]string{"cuisine/italian/sicilian"}, "cuisine", []string{"quick", "cuisine",
+			"cuisine/italian", "cuisines"}},
+		{[]string{"quick", "cuisines"}, "", []string{"cuisine", "cuisine/italian",
+			"cuisine/italian/sicilian"}},
+	}
+	for _, test := range tests {
+		got := retagTags(append([]string{}, tags...), test.from, test.to)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("retagTags(%v, %q) = %v, want %v", test.from, test.to, got, test.want)
+		}
+	}
+}
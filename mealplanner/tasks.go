@@ -0,0 +1,149 @@
+package mealplanner
+
+// background work, e.g. keeping the keyword index up to date, run
+//  through a work queue so that requests don't wait for it
+
+import (
+	"appengine"
+	"appengine/datastore"
+	"appengine/memcache"
+	"appengine/taskqueue"
+	"appengine/user"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// the IndexStatus of an item
+const (
+	// the item has changed, its keywords haven't been updated yet
+	indexPending = "pending"
+	// the keywords of the item are up to date
+	indexDone = "indexed"
+)
+
+// the most items indexed by one task
+const indexBatchSize = 50
+
+// handlers for the tasks, by path, set up by init
+var taskHandlers map[string]handlerFunc
+
+// a queue of background work, each task is the params POSTed to path
+type workQueue interface {
+	add(c *context, path string, params url.Values)
+}
+
+// work queue using the App Engine task queue, each task runs in its
+//  own request and is retried until it succeeds
+type taskWorkQueue struct{}
+
+func (self taskWorkQueue) add(c *context, path string, params url.Values) {
+	_, err := taskqueue.Add(c.c, taskqueue.NewPOSTTask(path, params), "")
+	check(err)
+}
+
+// work queue for development, runs each task right away as part of
+//  the request that adds it
+type localWorkQueue struct{}
+
+func (self localWorkQueue) add(c *context, path string, params url.Values) {
+	handler, found := taskHandlers[path]
+	if !found {
+		check(ErrUnsupported)
+	}
+	// run the handler as if the task had been POSTed
+	tc := *c
+	tc.r = &http.Request{
+		Method:   "POST",
+		URL:      &url.URL{Path: path},
+		Header:   make(http.Header),
+		Form:     params,
+		PostForm: params,
+	}
+	handler(&tc)
+}
+
+// get the queue for background work
+func (self *context) workQueue() workQueue {
+	if appengine.IsDevAppServer() {
+		return localWorkQueue{}
+	}
+	return taskWorkQueue{}
+}
+
+// taskHandler wraps the handlers of tasks, which don't run as a user,
+//  building the context for the library given by the "library" param
+// errors give an HTTP 500 error, so the task queue tries the task again
+func taskHandler(handler handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err, ok := recover().(error); ok {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprintf(w, "%v", err)
+			}
+		}()
+		c := appengine.NewContext(r)
+		// only the task queue sets this header, but administrators may
+		//  run tasks by hand
+		if len(r.Header.Get("X-AppEngine-QueueName")) == 0 && !user.IsAdmin(c) {
+			check(ErrPermissionDenied)
+		}
+		if r.Method != "POST" {
+			check(ErrUnsupported)
+		}
+		lid, err := datastore.DecodeKey(r.FormValue("library"))
+		check(err)
+		l := &Library{}
+		_, err = memcache.Gob.Get(c, lid.Encode(), l)
+		if err != nil {
+			check(datastore.Get(c, lid, l))
+		}
+		handler(&context{w, r, c, nil, "", l, lid, false})
+	}
+}
+
+// queue tasks to update the keywords of the items, in batches
+func queueIndex(c *context, keys ...*datastore.Key) {
+	for start := 0; start < len(keys); start += indexBatchSize {
+		end := start + indexBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		params := url.Values{"library": {c.lid.Encode()}}
+		for _, key := range keys[start:end] {
+			params.Add("key", key.Encode())
+		}
+		c.workQueue().add(c, "/task/index", params)
+	}
+}
+
+// handler for tasks to update the keywords of the items given by the
+//  "key" params
+func indexTaskHandler(c *context) {
+	for _, id := range c.r.Form["key"] {
+		key, err := datastore.DecodeKey(id)
+		check(err)
+		c.checkUser(key)
+		indexItem(c, key)
+	}
+}
+
+// update the keywords of the item, which marks it indexDone
+func indexItem(c *context, key *datastore.Key) {
+	item := newTaggedItem(key.Kind())
+	err := datastore.Get(c.c, key, item)
+	if err == datastore.ErrNoSuchEntity {
+		// the item was deleted after the task was queued
+		return
+	}
+	check(err)
+	switch item := item.(type) {
+	case *Dish:
+		updateDishKeywords(c, key, item)
+	case *Ingredient:
+		updateIngredientKeywords(c, key, item)
+	case *Menu:
+		item.migrateDishes()
+		updateMenuKeywords(c, key, item)
+	}
+}
@@ -111,16 +111,46 @@ jQuery(function() {
    })
    // model of pairing, which has suggestions for two dishes
    //  will be the child of one dish, pointing to another -- Description
-   //  is "Alternative" or "Recommended"
+   //  is the name of a PairingType, e.g. "Alternative" or "Sauce for"
    window.Pairing = MealplannerModel.extend({
       defaults : {
          Other : "",
-			Description : ""
+			Description : "",
+         Notes : "",
+         Strength : 0
       }
    });
    window.PairingList = MealplannerCollection.extend({
       model: Pairing
    })
+   // model of the kinds of pairings the library uses
+   window.PairingType = MealplannerModel.extend({
+      defaults : {
+         Name : "",
+         Inverse : ""
+      }
+   });
+   window.PairingTypeList = MealplannerCollection.extend({
+      url: "/pairingtype/",
+      model: PairingType,
+      comparator : function(pairingType) {
+         return pairingType.get("Name");
+      }
+   })
+   // model for a search saved under a name, Params holds the search as JSON
+   window.SavedSearch = MealplannerModel.extend({
+      defaults : {
+         Name : "",
+         Params : "{}"
+      }
+   });
+   window.SavedSearchList = MealplannerCollection.extend({
+      url: "/saved/",
+      model: SavedSearch,
+      comparator : function(saved) {
+         return saved.get("Name");
+      }
+   })
    // children of a dish that link to ingredients, including amount and instructions
    window.MeasuredIngredient = MealplannerModel.extend({
       defaults : {
@@ -249,29 +279,70 @@ jQuery(function() {
          return categories.sort();
       }
    })
-   // Model of menu, which lists dishes
+   // Model of menu, which lists dishes as items with servings, course and note
    window.Menu = MealplannerModel.extend({
-      defaults : {
+      defaults : function() { return {
 			Name : "<New Menu>",
-         Dishes : []
+         Items : []
+       };
+      },
+      // helper method to get the items sorted in the order they are served
+      sortedItems : function () {
+         return _.sortBy(this.get("Items") || [], function(item) {
+            return item.Position;
+         });
       },
       // helper method to know if menu lists the given dish
       // dish: Dish model
       hasDish : function (dish) {
-         var dishes = this.get("Dishes");
-         for(var d in dishes) {
-            if (dishes[d] == dish.id)
-               return true;
-         }
-         return false;
+         return _.any(this.get("Items"), function(item) {
+            return item.Dish == dish.id;
+         });
+      },
+      // helper method to add the specified dish to the end of the menu
+      addDish : function (dish) {
+         var items = _.clone(this.get("Items") || []);
+         var position = 0;
+         _.each(items, function(item) {
+            if (item.Position >= position)
+               position = item.Position + 1;
+         });
+         items.push({Dish: dish.id, Servings: 1, Course: "", Position: position, Note: ""});
+         this.save({Items:items});
+      },
+      // helper method to add the specified dishes to the end of the menu,
+      //  skipping those already listed, saves once
+      // dishes: array of Dish models
+      addDishes : function (dishes) {
+         var self = this;
+         var items = _.clone(this.get("Items") || []);
+         var position = 0;
+         _.each(items, function(item) {
+            if (item.Position >= position)
+               position = item.Position + 1;
+         });
+         _.each(dishes, function(dish) {
+            if (self.hasDish(dish)) return;
+            items.push({Dish: dish.id, Servings: 1, Course: "", Position: position, Note: ""});
+            position++;
+         });
+         this.save({Items:items});
+      },
+      // helper method to change fields of the item for the specified dish
+      updateDish : function (dish, attrs) {
+         var items = _.map(this.get("Items"), function(item) {
+            if (item.Dish == dish.id)
+               return _.extend({}, item, attrs);
+            return item;
+         });
+         this.save({Items:items});
       },
       // helper method to remove the specified dish
       removeDish : function (dish) {
-         var dishes = this.get("Dishes");
-         dishes = _.reject(dishes, function(id) {
-            return id == dish.id
+         var items = _.reject(this.get("Items"), function(item) {
+            return item.Dish == dish.id
          });
-         this.save({Dishes:dishes});
+         this.save({Items:items});
       }
    });
    window.MenuList = MealplannerCollection.extend({
@@ -719,7 +790,7 @@ jQuery(function() {
             var $text = $("<a></a>")
                .appendTo($tag)
                .text(tag.get("Word"))
-               .attr("href", "#search/" + tag.get("Word") + "//");
+               .attr("href", "#tag/" + tag.get("Word"));
 				if (!self.options.readOnly) {
             	var $delTag = $.makeRemoveIcon()
                	.appendTo($tag)
@@ -822,7 +893,8 @@ jQuery(function() {
          	   $.make("a")
             	   .appendTo($pairing)
             	   .text(pairing.other.get("Name"))
-            	   .attr("href", "#viewDish/" + pairing.other.id);
+            	   .attr("href", "#viewDish/" + pairing.other.id)
+                  .attr("title", pairing.pairing.get("Notes"));
 					if(!self.options.readOnly) {
          	   	var $delTag = $.makeRemoveIcon()
             	   	.appendTo($pairing)
@@ -834,9 +906,9 @@ jQuery(function() {
 			   });
          });
          // add the menus
-         var dishId = this.model.id;
+         var dish = this.model;
          var menus = Menus.filter(function(menu) {
-            return _.indexOf(menu.get("Dishes"), dishId) != -1;
+            return menu.hasDish(dish);
          });
          if (menus.length > 0) {
 			   $.make("div")
@@ -872,22 +944,25 @@ jQuery(function() {
          // create a dialog with the names and buttos to pick the description
 		   var $dialog =$("<div></div>").appendTo(document.body);
 		   $dialog.text("For " + self.model.get("Name") + " and " + other.get("Name") + "?")
+         // make a button for each type of pairing in the library
+         var names = PairingTypes.pluck("Name");
+         if (names.length == 0) {
+            names = ["Together", "Alternative"];
+         }
+         var buttons = {};
+         _.each(names, function(name) {
+            buttons[name] = function() {
+               self.addPairing(name, other);
+               $(this).dialog("close");
+            };
+         });
+         buttons.Cancel = function() {
+            $(this).dialog("close");
+         };
 		   $dialog.dialog({
 			   title : "What kind of suggestion?",
 			   modal: true,
-			   buttons : {
-				   Together : function() {
-					   self.addPairing("Together", other);
-					   $(this).dialog("close");
-				   },
-				   Alternative : function() {
-					   self.addPairing("Alternative", other);
-					   $(this).dialog("close");
-				   },
-				   Cancel : function() {
-					   $(this).dialog("close");
-				   }
-			   }
+			   buttons : buttons
 		   });
 	   },
       // render a list of items, using the specified css class, the root of the
@@ -935,6 +1010,13 @@ jQuery(function() {
             var rating = item.get("Rating");
             if (rating && rating > 0) {
                $td.append("<span class='summary'><span class='ui-icon ui-icon-star rating count'></span>"+rating+"</span>");
+            }
+            // show why the item matched the search
+            var snippet = self.options.snippets && self.options.snippets[item.id];
+            if (snippet) {
+               $.make("div", {"class": "snippet"})
+                  .append($.makeHighlighted(snippet.Snippet, snippet.Offsets))
+                  .appendTo($td);
             }
 			   $li[0].model = item;
          });
@@ -976,14 +1058,14 @@ jQuery(function() {
                 $input.val() != "<New Menu>")
             {
                var newAttrs = {Name:$input.val(),
-                  Dishes:self.model.get("Dishes")};
+                  Items:self.model.get("Items")};
                var newMenu = Menus.create(newAttrs, {
                   success : function(model, resp, shr) {
                      $dialog.dialog("close");
                      App.viewMenu(newMenu);
                      if (self.model == Menus.getDraftMenu()) {
                         // empty the draft menu
-                        self.model.save({Dishes: [] });
+                        self.model.save({Items: [] });
                      }
                   }});
             }
@@ -1026,6 +1108,15 @@ jQuery(function() {
          return this;
       },
    })
+   // menu list view, simply uses renderItemNameList
+   window.MenuListView = window.MealplannerView.extend({
+      tagName : "ul",
+      className : "menu-list",
+      render : function() {
+         this.renderItemNameList("menu", "viewMenu", "menus");
+         return this;
+      },
+   })
    // simple view to show a dish
    window.DishView = window.MealplannerView.extend({
       tagName : "div",
@@ -1033,6 +1124,7 @@ jQuery(function() {
       icon: "ui-icon-dish",
       buttons : [
          {label:"Edit", title: "Edit This Dish", click: "edit" },
+         {label:"Cooked", title: "Record That You Cooked This Dish", click: "cooked" },
          {label:"Delete", title: "Delete This Dish", click: "del" },
       ],
       initialize: function() {
@@ -1040,8 +1132,9 @@ jQuery(function() {
          this.$vegIcon = null;
          // call the initialize from the base "class"
          MealplannerView.prototype.initialize.call(this);
-         // bind the callback
+         // bind the callbacks
          _.bindAll(this, "edit");
+         _.bindAll(this, "cooked");
          // bind event handlers for the nested collections
          this.model.tags.bind('all', this.render);
          this.model.ingredients.bind('all', this.render);
@@ -1067,6 +1160,9 @@ jQuery(function() {
          this.$cookTime = $("<span class='dish-time'></span>")
             .appendTo($ctField);
          $ctField.append(" minutes")
+         // how often the dish has been cooked, see the "Cooked" button
+         this.$cookedCount = $("<span class='dish-cooked'></span>")
+            .appendTo(this.newField("Cooked"));
    
          // add the "servings" views to track nutrition
          var $breakdown = $("<table class='breakdown'></table>")
@@ -1130,6 +1226,15 @@ jQuery(function() {
          this.$type.text(this.model.get("DishType"));
          this.$prepTime.text(this.model.get("PrepTimeMinutes"));
          this.$cookTime.text(this.model.get("CookTimeMinutes"));
+         var cookedCount = this.model.get("CookedCount") || 0;
+         if (cookedCount == 0) {
+            this.$cookedCount.text("never");
+         } else {
+            var lastCooked = new Date(this.model.get("LastCooked"));
+            this.$cookedCount.text(cookedCount +
+               (cookedCount == 1 ? " time" : " times") +
+               ", last on " + lastCooked.toLocaleDateString());
+         }
          // turn source into a hyperlink if it is a URL
          var source = this.model.get("Source");
          if (source.indexOf("http://") == 0 ||
@@ -1200,6 +1305,15 @@ jQuery(function() {
       edit: function(ev) {
          this.trigger("editDish", this.model);
       },
+      // record that the dish was cooked today, the server sends back the
+      //  dish with its new CookedCount and LastCooked
+      cooked: function(ev) {
+         var self = this;
+         jQuery.post(this.model.url() + "/cooked", "", function(dish) {
+            self.model.set({CookedCount: dish.CookedCount,
+               LastCooked: dish.LastCooked});
+         });
+      },
    });
    // view for editing a dish
    window.DishEditView = window.MealplannerView.extend({
@@ -1292,7 +1406,21 @@ jQuery(function() {
             .appendTo($lastRow);
          this.$addIngredient = $("<input type='text'></input>")
             .appendTo($addCell)
-            .combo({source: allIngredients})
+            .combo({source: function(request, response) {
+                  // show them all for the drop-down, otherwise ask the
+                  //  server for names starting with what's typed
+                  if (request.term.length == 0) {
+                     response(allIngredients);
+                     return;
+                  }
+                  jQuery.getJSON("/suggest",
+                     {q: request.term, kind: "ingredient"},
+                     function(completions) {
+                        response(_.map(completions, function(c) {
+                           return c.Name;
+                        }));
+                     });
+               }})
             .bind('keypress', function (evt) {
                if (evt.which == 13) {
                   evt.preventDefault();
@@ -1907,6 +2035,8 @@ jQuery(function() {
          _.bindAll(this, "cloneMenu");
          _.bindAll(this, "addCurDish");
          _.bindAll(this, "newDish");
+         _.bindAll(this, "addSavedSearch");
+         _.bindAll(this, "renderSaved");
          // bind to the events happening on all dishes so we can
          // update name and nutrition
          Dishes.bind('all', this.render);
@@ -1929,6 +2059,14 @@ jQuery(function() {
          // create dish list
          this.$dishes = $("<ul class='dish-list'></ul>")
             .appendTo(this.newField("Dishes"));
+         // saved searches, pick one to add the dishes it finds
+			if (!this.options.readOnly) {
+            this.$saved = $.make("select")
+               .change(this.addSavedSearch)
+               .appendTo(this.newField("From Saved Search"));
+            SavedSearches.bind("all", this.renderSaved);
+            this.renderSaved();
+			}
          var $p = $("<p></p>")
             .appendTo(this.$fields);
             
@@ -1966,20 +2104,18 @@ jQuery(function() {
          // prepare a list of dishes
          var self = this;
          this.$dishes.children().remove();
-         var dishIds = this.model.get("Dishes");
-         var dishes = _.map(dishIds, function(id) {
-               return Dishes.get(id);
-            });
-         // remove any missing dishes
-         dishes = _.compact(dishes);
-         dishes = _.sortBy(dishes, function(dish) {
-               return dish.get("Name");
+         // get the dishes in the order they are served, skipping
+         //  any that are missing
+         var items = _.filter(this.model.sortedItems(), function(item) {
+               return Dishes.get(item.Dish);
             });
          var veggies = 0;
          var protein = 0;
          var carbs = 0;
          // create view of each item and sum the nutrition
-         _.each(dishes, function(dish) {
+         _.each(items, function(item) {
+            var dish = Dishes.get(item.Dish);
+            var servings = item.Servings || 1;
             var $li = $("<li class='dish'></li>")
                .append($.makeIcon('ui-icon-dish'))
                .appendTo(self.$dishes)
@@ -1989,6 +2125,9 @@ jQuery(function() {
                   .appendTo($li)
                   .text(name)
                   .attr("href", "#viewDish/" + dish.id);
+            if (servings != 1) {
+               $li.append(" &times;" + servings);
+            }
 			   $li[0].model = dish;
 				if (!self.options.readOnly) {
             	var $delTag = $.makeRemoveIcon()
@@ -1999,12 +2138,12 @@ jQuery(function() {
                   	})
                	.autoHide({handle:$li});
 				}
-            veggies += dish.get("ServingsVeggies");  
-            protein += dish.get("ServingsProtein");  
-            carbs += dish.get("ServingsCarb");  
+            veggies += dish.get("ServingsVeggies") * servings;
+            protein += dish.get("ServingsProtein") * servings;
+            carbs += dish.get("ServingsCarb") * servings;
          });
          // if no dishes, give instructions for adding them
-         if (dishes.length == 0) {
+         if (items.length == 0) {
             self.$dishes.append("<li class='dish'>Drag dishes here to add them to the menu, or click the 'Add' button above.</li>");
          }
          // update button state
@@ -2031,19 +2170,42 @@ jQuery(function() {
       },
       // handle clearing the menu
       clearMenu : function() {
-         this.model.save({Dishes:[]});
+         this.model.save({Items:[]});
          this.render();
       },
       // handle drop of a new dish
       newDish : function(evt, ui) {
 		   var other = ui.draggable[0].model;
          if (other && !this.model.hasDish(other)) {
-            var dishes = this.model.get("Dishes");
-            dishes.push(other.id);
-            this.model.save({Dishes:dishes});
+            this.model.addDish(other);
             this.render();
          }
       },
+      // update the list of saved searches to choose from
+      renderSaved : function() {
+         var self = this;
+         this.$saved.children().remove();
+         $.make("option", {value: ""}).appendTo(this.$saved);
+         SavedSearches.each(function(saved) {
+            $.make("option", {value: saved.id})
+               .text(saved.get("Name"))
+               .appendTo(self.$saved);
+         });
+      },
+      // add the dishes the chosen saved search finds now
+      addSavedSearch : function() {
+         var self = this;
+         var saved = SavedSearches.get(this.$saved.val());
+         this.$saved.val("");
+         if (!saved) return;
+         jQuery.getJSON(saved.url() + "/dishes", function(ids) {
+            var dishes = _.compact(_.map(ids, function(id) {
+               return Dishes.get(id);
+            }));
+            self.model.addDishes(dishes);
+            self.render();
+         });
+      },
       // get the dish from the current context
       curDish : function() {
          if (App.curContext) {
@@ -2056,9 +2218,7 @@ jQuery(function() {
       addCurDish : function() {
 		   var other = this.curDish();
          if (other && !this.model.hasDish(other)) {
-            var dishes = this.model.get("Dishes");
-            dishes.push(other.id);
-            this.model.save({Dishes:dishes});
+            this.model.addDish(other);
          }
          this.render();
       },
@@ -2144,20 +2304,19 @@ jQuery(function() {
          //  and gathering other data for nutrition
          var self = this;
          this.$dishes.children().remove();
-         var dishIds = this.model.get("Dishes");
-         var dishes = _.map(dishIds, function(id) {
-               return Dishes.get(id);
-            });
-         // remove any missing dishes
-         dishes = _.compact(dishes);
-         dishes = _.sortBy(dishes, function(dish) {
-               return dish.get("Name");
+         // get the dishes in the order they are served, skipping
+         //  any that are missing
+         var items = _.filter(this.model.sortedItems(), function(item) {
+               return Dishes.get(item.Dish);
             });
          var veggies = 0;
          var protein = 0;
          var carbs = 0;
          var allIngredients = {};
-         _.each(dishes, function(dish) {
+         var lastCourse = "";
+         _.each(items, function(item) {
+            var dish = Dishes.get(item.Dish);
+            var servings = item.Servings || 1;
             dish.ingredients.fetchOnce({success: self.render});
             dish.ingredients.each(function(ingredient) {
                var model = Ingredients.get(ingredient.get("Ingredient"));
@@ -2174,6 +2333,13 @@ jQuery(function() {
                   }
                }
             });
+            // show a heading when the course changes
+            if (item.Course && item.Course != lastCourse) {
+               $.make("li", {"class":"pairing-head"})
+                  .text(item.Course)
+                  .appendTo(self.$dishes);
+            }
+            lastCourse = item.Course;
             var $li = $("<li class='dish'></li>")
 					.append($.makeIcon("ui-icon-dish"))
                .appendTo(self.$dishes)
@@ -2185,6 +2351,45 @@ jQuery(function() {
                   .text(name)
                   .attr("href", "#viewDish/" + dish.id);
             $li.append( " " + dish.get("PrepTimeMinutes") + " + " + dish.get("CookTimeMinutes") + " = " + (parseInt(dish.get("PrepTimeMinutes")) + parseInt(dish.get("CookTimeMinutes"))) + " minutes");
+            if (self.options.readOnly) {
+               if (servings != 1) {
+                  $li.append(" &times;" + servings);
+               }
+               if (item.Note) {
+                  $.make("div", {"class":"summary"})
+                     .text(item.Note)
+                     .appendTo($li);
+               }
+            } else {
+               // allow the servings, course and note to be changed
+               $li.append(" &times;");
+               $.make("input", {type:"text", size:"3", title:"Batches"})
+                  .val(servings)
+                  .appendTo($li)
+                  .bind("change", function(evt) {
+                     // don't let the view treat this as an edit to save
+                     evt.stopPropagation();
+                     var value = parseFloat($(this).val());
+                     if (!isNaN(value) && value > 0)
+                        self.model.updateDish(dish, {Servings: value});
+                  });
+               $.make("input", {type:"text", size:"8", title:"Course"})
+                  .val(item.Course)
+                  .appendTo($li)
+                  .bind("change", function(evt) {
+                     // don't let the view treat this as an edit to save
+                     evt.stopPropagation();
+                     self.model.updateDish(dish, {Course: $(this).val()});
+                  });
+               $.make("input", {type:"text", size:"20", title:"Note"})
+                  .val(item.Note)
+                  .appendTo($li)
+                  .bind("change", function(evt) {
+                     // don't let the view treat this as an edit to save
+                     evt.stopPropagation();
+                     self.model.updateDish(dish, {Note: $(this).val()});
+                  });
+            }
 				if (!self.options.readOnly) {
             	var $delTag = $.makeRemoveIcon()
                	.appendTo($li)
@@ -2194,9 +2399,9 @@ jQuery(function() {
                   	})
                	.autoHide({handle: $li});
 				}
-            veggies += dish.get("ServingsVeggies");  
-            protein += dish.get("ServingsProtein");  
-            carbs += dish.get("ServingsCarb");  
+            veggies += dish.get("ServingsVeggies") * servings;
+            protein += dish.get("ServingsProtein") * servings;
+            carbs += dish.get("ServingsCarb") * servings;
          });
 
          // prepare consolidated list of ingredients
@@ -2236,16 +2441,14 @@ jQuery(function() {
       },
       // handle clearing the menu
       clearMenu : function() {
-         this.model.save({Dishes:[]});
+         this.model.save({Items:[]});
          this.render();
       },
       // handle drop of a new dish
       newDish : function(evt, ui) {
 		   var other = ui.draggable[0].model;
          if (other && !this.model.hasDish(other)) {
-            var dishes = this.model.get("Dishes");
-            dishes.push(other.id);
-            this.model.save({Dishes:dishes});
+            this.model.addDish(other);
             this.render();
          }
       },
@@ -2519,6 +2722,12 @@ jQuery(function() {
          _.bindAll(this, "startSearch");
          _.bindAll(this, "render");
          _.bindAll(this, "searchComplete");
+         _.bindAll(this, "requestPage");
+         _.bindAll(this, "pageReceived");
+         _.bindAll(this, "moreResults");
+         _.bindAll(this, "renderOtherResults");
+         _.bindAll(this, "saveSearch");
+         _.bindAll(this, "renderSaved");
          _.bindAll(this, "textSearch");
          _.bindAll(this, "search");
          _.bindAll(this, "addSearchSuggestions");
@@ -2566,6 +2775,32 @@ jQuery(function() {
             .bind('autocompletechange', this.parseTags)
             .bind('change', this.parseTags)
             .appendTo($tagField);
+         // option to also search the libraries shared with us
+         this.$allLibraries = $("<input type='checkbox'></input>")
+            .change(function() {
+               self.model.set({AllLibraries: self.$allLibraries.is(":checked")});
+            })
+            .appendTo(this.newField("All Libraries"));
+         // saved searches, pick one to run it or save the current search
+         var $savedField = this.newField("Saved Searches");
+         this.$saved = $.make("select")
+            .change(function() {
+               var saved = SavedSearches.get(self.$saved.val());
+               if (!saved) return;
+               var params = JSON.parse(saved.get("Params"));
+               // we filter on rating locally
+               params.Rating = params.MinRating || 0;
+               delete params["MinRating"];
+               self.model.clear({silent: true});
+               self.model.set(params);
+            })
+            .appendTo($savedField);
+         $.make("button")
+            .button({label: "Save"})
+            .click(this.saveSearch)
+            .appendTo($savedField);
+         SavedSearches.bind("all", this.renderSaved);
+         this.renderSaved();
          // setup results section
          this.$results = $.make("div")
             .appendTo(this.el);
@@ -2580,6 +2815,11 @@ jQuery(function() {
             model : window.Ingredients,
             searchResults: [],
          });
+         // create menu list view for menu results
+         this.menuListView = new MenuListView({
+            model : window.Menus,
+            searchResults: [],
+         });
          // start the search
          this.startSearch();
       },
@@ -2600,7 +2840,7 @@ jQuery(function() {
       },
       // update our search with the text
       textSearch : function() {
-         this.model.set({Word: this.$words.val()});
+         this.model.set({Query: this.$words.val()});
       },
       startSearch : function() {
          // start our query
@@ -2625,22 +2865,92 @@ jQuery(function() {
          //  filter to be done
          var tags = this.model.get("Tags");
          var word = this.model.get("Word");
+         var text = $.trim(this.model.get("Query") || "");
+         this.didYouMean = [];
+         this.otherResults = [];
+         this.nextCursor = "";
+         this.pendingSnippets = {};
          if ( ((!tags) || tags.length == 0) 
-             && ((!word) || word.length == 0)) {
+             && ((!word) || word.length == 0)
+             && text.length == 0) {
             if (Dishes.length > 0 && Ingredients.length > 0) {
                var dishes = {};
                Dishes.each(function(i) { return dishes[i.id] = 1; })
                var ings = {};
                Ingredients.each(function(i) { return ings[i.id] = 1;})
+               var menus = {};
+               Menus.each(function(i) { return menus[i.id] = 1;})
                this.searchComplete({
                   Dish : dishes,
-                  Ingredient : ings
+                  Ingredient : ings,
+                  Menu : menus
                });
             }
          } else {
-            jQuery.post("/search", query, this.searchComplete);
+            this.pendingResults = {Dish: {}, Ingredient: {}, Menu: {}};
+            this.pendingSnippets = {Dish: {}, Ingredient: {}, Menu: {}};
+            this.pendingRank = 0;
+            this.requestPage(query, "");
          }
       },
+      // request a page of ranked results from the server
+      requestPage : function(query, cursor) {
+         var self = this;
+         var attrs = JSON.parse(query);
+         attrs.Cursor = cursor;
+         attrs.Limit = 50;
+         jQuery.post("/search", JSON.stringify(attrs), function(page) {
+            // ignore pages for a search that has been replaced
+            if (self.lastQuery != query) return;
+            self.pageReceived(query, page);
+         });
+      },
+      // handle a page of ranked results from the server
+      // they come as a list, best first, and are added to the
+      //  dictionaries searchComplete takes, later pages are only
+      //  requested when the user asks for more
+      pageReceived : function(query, page) {
+         var self = this;
+         var results = this.pendingResults;
+         // the server suggests corrections when nothing matched
+         if (page.DidYouMean) {
+            this.didYouMean = page.DidYouMean;
+            this.corrected = page.Corrected;
+         }
+         var collections = {Dish: Dishes, Ingredient: Ingredients, Menu: Menus};
+         _.each(page.Results, function(result) {
+            result.highlight = self.matchSnippet(result);
+            // items from other libraries aren't in our collections
+            if (!collections[result.Kind].get(result.Id)) {
+               self.otherResults.push(result);
+               return;
+            }
+            // higher counts are listed first, so count down to keep
+            //  the server's order
+            results[result.Kind][result.Id] = Math.max(9999 - self.pendingRank, 0);
+            self.pendingRank++;
+            if (result.highlight) {
+               self.pendingSnippets[result.Kind][result.Id] = result.highlight;
+            }
+         });
+         this.nextCursor = page.Cursor;
+         this.searchComplete(results);
+      },
+      // request the next page of the results shown
+      moreResults : function() {
+         if (!this.nextCursor) return;
+         var cursor = this.nextCursor;
+         // only ask for each page once
+         this.nextCursor = "";
+         this.requestPage(this.lastQuery, cursor);
+      },
+      // get the highlighted words from the result's fields, other than
+      //  the name which is shown anyway
+      matchSnippet : function(result) {
+         return _.find(result.Highlights || [], function(highlight) {
+            return highlight.Field != "Name";
+         });
+      },
       // handle incoming search results
       // they come in the form of a dictionary:
       // { id : count, id : count, ...}
@@ -2653,10 +2963,18 @@ jQuery(function() {
          if (! ("Ingredient" in results)) {
             results.Ingredient = {};
          }
+         if (! ("Menu" in results)) {
+            results.Menu = {};
+         }
+         var snippets = this.pendingSnippets || {};
          this.dishListView.options.searchResults = results.Dish;
+         this.dishListView.options.snippets = snippets.Dish;
          this.dishListView.options.minRating = this.model.get("Rating");
          this.ingredientListView.options.searchResults
             = results.Ingredient;
+         this.ingredientListView.options.snippets = snippets.Ingredient;
+         this.menuListView.options.searchResults = results.Menu;
+         this.menuListView.options.snippets = snippets.Menu;
          this.render();
       },
       // update our view based on the query we're building
@@ -2671,22 +2989,93 @@ jQuery(function() {
          }
          this.renderTags();
          this.$results.html("");
+         // offer the corrections for misspelled words
+         if (this.didYouMean && this.didYouMean.length > 0) {
+            var self = this;
+            var $suggest = $.make("div", {"class": "did-you-mean"})
+               .text(this.corrected ? "Showing results for " : "Did you mean ")
+               .appendTo(this.$results);
+            _.each(this.didYouMean, function(query, idx) {
+               if (idx > 0) {
+                  $suggest.append(document.createTextNode(", "));
+               }
+               $.make("a", {href: "javascript:;"})
+                  .text(query)
+                  .click(function() {
+                     self.model.set({Query: query, Tags: [], Word: ""});
+                  })
+                  .appendTo($suggest);
+            });
+         }
          if (this.dishListView || this.ingredientListView) {
             this.$results.append("<div class='field-head'>Dishes</div>");
             this.$results.append(this.dishListView.render().el);
             this.$results.append("<div class='field-head'>Ingredients</div>");
             this.$results.append(this.ingredientListView.render().el);
+            this.$results.append("<div class='field-head'>Menus</div>");
+            this.$results.append(this.menuListView.render().el);
+            this.renderOtherResults();
+            if (this.nextCursor) {
+               $.make("button")
+                  .button({label: "More Results"})
+                  .click(this.moreResults)
+                  .appendTo(this.$results);
+            }
          } else {
             this.$results.html("Searching...");
          }
          if (this.model) {
 			   var words = "";
-			   if (this.model.get("Word"))
+			   if (this.model.get("Query"))
+				   words = this.model.get("Query");
+			   else if (this.model.get("Word"))
 				   words = this.model.get("Word");
 			   this.$words.val($.trim(words))
+            this.$allLibraries.attr("checked", !!this.model.get("AllLibraries"));
          }
          return this;
       },
+      // save the current search under a name the user gives
+      saveSearch : function() {
+         var name = prompt("Name for this search:");
+         if (!name) return;
+         var params = $.extend({}, this.model.attributes);
+         params.MinRating = params.Rating;
+         delete params["Rating"];
+         SavedSearches.create({Name: name, Params: JSON.stringify(params)});
+      },
+      // update the list of saved searches to choose from
+      renderSaved : function() {
+         this.$saved.children().remove();
+         $.make("option", {value: ""}).appendTo(this.$saved);
+         var self = this;
+         SavedSearches.each(function(saved) {
+            $.make("option", {value: saved.id})
+               .text(saved.get("Name"))
+               .appendTo(self.$saved);
+         });
+      },
+      // list the results from other libraries, linking to switch to
+      //  the library and view the item
+      renderOtherResults : function() {
+         if (!this.otherResults || this.otherResults.length == 0) return;
+         this.$results.append("<div class='field-head'>Other Libraries</div>");
+         var $list = $.make("ul").appendTo(this.$results);
+         _.each(this.otherResults, function(result) {
+            var $li = $.make("li").appendTo($list);
+            $.make("a", {href: "/switch/" + result.Library + "#view"
+                         + result.Kind + "/" + result.Id})
+               .text(result.Name)
+               .appendTo($li);
+            $li.append(document.createTextNode(" (" + result.LibraryName + ")"));
+            if (result.highlight) {
+               $.make("div", {"class": "snippet"})
+                  .append($.makeHighlighted(result.highlight.Snippet,
+                                            result.highlight.Offsets))
+                  .appendTo($li);
+            }
+         });
+      },
       // update the view of the tags being filtered
       renderTags : function () {
          var self = this;
@@ -2719,17 +3108,31 @@ jQuery(function() {
       },
       // update autocomplete with our list of tags
       //  as suggestions
-      addSearchSuggestions : function(tags) {
+      addSearchSuggestions : function(tree) {
+         var tags = flattenTags(tree);
          this.$words.autocomplete({source: tags});
          this.$newTags.autocomplete({source: tags});
       }
    })
 
+   // get the list of tags, parents first, from the tree of tags
+   //  given by /tags
+   function flattenTags(tree, tags) {
+      tags = tags || [];
+      _.each(tree, function(node) {
+         tags.push(node.Tag);
+         flattenTags(node.Children, tags);
+      });
+      return tags;
+   }
+
    // setup global collections
    window.Users = new UserList
    window.Dishes = new DishList
    window.Ingredients = new IngredientList
    window.Menus = new MenuList
+   window.PairingTypes = new PairingTypeList
+   window.SavedSearches = new SavedSearchList
    // setup the router, so we can track in the broswer history and
    //  bookmark individual views
    var Router = Backbone.Router.extend({
@@ -2740,6 +3143,8 @@ jQuery(function() {
          "editIngredient/:id": "editIngredient",
          "viewMenu/:id": "viewMenu",
          "search/:tag/:word/:rating": "search",
+         // tags may have "/" between parent and child
+         "tag/*tag": "searchTag",
          "tutorial": "viewTutorial",
          "about": "viewAbout",
       },
@@ -2779,11 +3184,14 @@ jQuery(function() {
          if (tag)
             attrs.Tags = [tag];
          if (word)
-            attrs.Word = word;
+            attrs.Query = word;
          if (rating)
             attrs.Rating = parseInt(rating);
          var search = new Search(attrs);
          window.App.search(search);
+      },
+      searchTag : function(tag) {
+         this.search(tag, "", 0);
       }
    });
    window.Workspace = new Router();
@@ -2796,6 +3204,7 @@ jQuery(function() {
          _.bindAll(this, "newDish");
          _.bindAll(this, "editDish");
          _.bindAll(this, "viewDish");
+         _.bindAll(this, "randomDish");
          _.bindAll(this, "newIngredient");
          _.bindAll(this, "viewIngredient");
          _.bindAll(this, "editIngredient");
@@ -2828,7 +3237,10 @@ jQuery(function() {
                   .click(this.newDish);
          this.el.find(".add-ingredient")
                   .button({icons : {primary:"ui-icon-pencil"}})
-                  .click(this.newIngredient)
+                  .click(this.newIngredient);
+         this.el.find(".random-dish")
+                  .button({icons : {primary:"ui-icon-shuffle"}})
+                  .click(this.randomDish)
                   .parent()
                      .buttonset();
          // setup fetch counter so we can know when to remove the LoadingView
@@ -2847,6 +3259,8 @@ jQuery(function() {
          Users.fetch({success:this.onFetched, error:this.onFetched});
          Dishes.fetch({success:this.onFetched, error:this.onFetched});
          Ingredients.fetch({success:this.onFetched, error:this.onFetched});
+         PairingTypes.fetch();
+         SavedSearches.fetch();
          // fetch tags
          jQuery.getJSON("/tags", this.renderTags);
       },
@@ -2902,18 +3316,26 @@ jQuery(function() {
          this.userView.render();
          return this
       },
-      // update the tags tab with the list of tags
-      renderTags : function(tags) {
-         var $tags = $("#tags");
-         for (var tag in tags)
-         {
+      // update the tags tab with the tree of tags, children are
+      //  listed under their parents
+      renderTags : function(tree) {
+         this.renderTagTree(tree, $("#tags"));
+      },
+      // add the tags to the list $tags, with lists for their children
+      renderTagTree : function(tree, $tags) {
+         var self = this;
+         _.each(tree, function(node) {
             var $li = $("<li class='tag'></li>")
                .appendTo($tags);
             $.make("a")
                .appendTo($li)
-               .text(tags[tag])
-               .attr("href", "#search/" + tags[tag] + "//");
-         }
+               .text(node.Name)
+               .attr("href", "#tag/" + node.Tag);
+            if (node.Children && node.Children.length > 0) {
+               self.renderTagTree(node.Children,
+                                  $("<ul class='tag-children'></ul>").appendTo($li));
+            }
+         });
       },
       // show a view in the main pane
       show : function(view) {
@@ -2969,6 +3391,17 @@ jQuery(function() {
          // save this to browser history
          window.Workspace.navigate("viewDish/" + dish.id);
       },
+      // view a dish picked at random, favoring better rated dishes that
+      //  haven't been cooked lately, see the "Cooked" button of DishView
+      randomDish : function() {
+         var self = this;
+         jQuery.post("/dish/random", JSON.stringify({Weighted: true}), function(dish) {
+            // null if the library has no dishes
+            var m = dish && Dishes.get(dish.Id);
+            if (m)
+               self.viewDish(m);
+         });
+      },
       // create a new edit view for a dish
       editDish : function(dish) {
          // for read-only, route to view
@@ -149,6 +149,24 @@
       });
       return this;
    }
+   // make and return a span showing the text with words highlighted
+   //  offsets is a list of [start, end] of each word, counted in characters
+   // returns jQuery object
+   $.makeHighlighted = function(text, offsets) {
+      var $span = $.make("span");
+      // split into characters, keeping surrogate pairs together
+      var chars = text.match(/[\uD800-\uDBFF][\uDC00-\uDFFF]|[\s\S]/g) || [];
+      var at = 0;
+      _.each(offsets || [], function(offset) {
+         if (offset[0] < at) return;
+         $span.append(document.createTextNode(chars.slice(at, offset[0]).join("")));
+         $.make("b").text(chars.slice(offset[0], offset[1]).join(""))
+            .appendTo($span);
+         at = offset[1];
+      });
+      $span.append(document.createTextNode(chars.slice(at).join("")));
+      return $span;
+   }
    // zero fills the string, adding leading zeros to make the length total at least count
    $.zfill = function (str, count) {
       var zeros = count - str.toString().length;